@@ -19,6 +19,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/procfile"
 	"github.com/ahmetb/rundev/lib/types"
 	"github.com/pkg/errors"
 	"io/ioutil"
@@ -26,24 +28,52 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const (
-	dumbInitURL = `https://github.com/Yelp/dumb-init/releases/download/v1.2.2/dumb-init_1.2.2_amd64`
-	rundevdURL  = `https://storage.googleapis.com/rundev-test/rundevd-v0.0.0-b0bb9a5`
+	// rundevd release binaries are uploaded by hack/upload-rundevd-release.sh
+	rundevdReleaseURLPrefix = `https://storage.googleapis.com/rundev-test/rundevd-`
 )
 
+// rundevdReleaseURL returns the URL of the rundevd binary released along with
+// this version of the client, so the client and the daemon always understand
+// the same flags and protocol.
+func rundevdReleaseURL() (string, error) {
+	if constants.Version == "" || constants.Version == "dev" {
+		return "", errors.New("this client is not a release build, specify the rundevd binary to deploy with -rundevd-url " +
+			"(e.g. upload one built from this tree with hack/upload-rundevd-release.sh)")
+	}
+	return rundevdReleaseURLPrefix + constants.Version, nil
+}
+
 type remoteRunOpts struct {
-	syncDir      string
+	rundevdURL   string // downloaded to the image as /bin/rundevd
+	mounts       types.Mounts
+	workDir      string
 	runCmd       types.Cmd
 	buildCmds    types.BuildCmds
+	procs        types.Processes
+	reloadRules  types.ReloadRules
 	clientSecret string
-	ignoreRules  []string
+	eagerBuild   bool
+	stopSignal   string // daemon's default if empty
+	stopGrace    time.Duration
+	restart      string // restart policy
+	blueGreen    bool
+	worker       bool // the app doesn't listen on $PORT
+
+	// HTTP readiness probe, the daemon waits for the port if readyPath is
+	// empty, and uses its defaults for zero durations
+	readyPath     string
+	readyInterval time.Duration
+	readyTimeout  time.Duration
 }
 
 type buildOpts struct {
 	dir        string
 	image      string
+	buildArgs  map[string]string
 	dockerfile []byte
 }
 
@@ -52,7 +82,11 @@ func dockerBuildPush(ctx context.Context, opts buildOpts) error {
 	if err != nil {
 		errors.Wrapf(err, "local docker engine is unreachable, output=%s", string(b))
 	}
-	args := []string{"build", "--tag=" + opts.image, opts.dir}
+	args := []string{"build", "--tag=" + opts.image}
+	for k, v := range opts.buildArgs {
+		args = append(args, "--build-arg="+k+"="+v)
+	}
+	args = append(args, opts.dir)
 	if len(opts.dockerfile) > 0 {
 		args = append(args, "--file=-")
 	}
@@ -80,6 +114,30 @@ func readDockerfile(dir string) ([]byte, error) {
 	return df, nil
 }
 
+// readProcfile parses the named processes in the Procfile at path, if it
+// exists. The web process is skipped, as the app is started with the run cmd.
+func readProcfile(path string) (types.Processes, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error reading Procfile")
+	}
+	defer f.Close()
+	procs, err := procfile.Parse(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+	var out types.Processes
+	for _, p := range procs {
+		if p.Name == "web" {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
 func prepEntrypoint(opts remoteRunOpts) string {
 	rc, _ := json.Marshal(opts.runCmd)
 	cmd := []string{"/bin/rundevd",
@@ -90,18 +148,50 @@ func prepEntrypoint(opts remoteRunOpts) string {
 		bc, _ := json.Marshal(opts.buildCmds)
 		cmd = append(cmd, "-build-cmds", string(bc))
 	}
-	if len(opts.ignoreRules) > 0 {
-		b, _ := json.Marshal(opts.ignoreRules)
-		cmd = append(cmd, "-ignore-patterns", string(b))
+	if len(opts.procs) > 0 {
+		b, _ := json.Marshal(opts.procs)
+		cmd = append(cmd, "-procs", string(b))
+	}
+	if len(opts.reloadRules) > 0 {
+		b, _ := json.Marshal(opts.reloadRules)
+		cmd = append(cmd, "-reload-rules", string(b))
+	}
+	if len(opts.mounts) > 0 {
+		b, _ := json.Marshal(opts.mounts)
+		cmd = append(cmd, "-mounts", string(b))
+	}
+	if opts.workDir != "" {
+		cmd = append(cmd, "-work-dir="+opts.workDir)
+	}
+	if opts.eagerBuild {
+		cmd = append(cmd, "-eager-build")
+	}
+	if opts.stopSignal != "" {
+		cmd = append(cmd, "-stop-signal="+opts.stopSignal)
 	}
-	if opts.syncDir != "" {
-		cmd = append(cmd, "-sync-dir="+opts.syncDir)
+	cmd = append(cmd, "-stop-grace-period="+opts.stopGrace.String())
+	if opts.restart != "" {
+		cmd = append(cmd, "-restart-policy="+opts.restart)
+	}
+	if opts.blueGreen {
+		cmd = append(cmd, "-blue-green")
+	}
+	if opts.worker {
+		cmd = append(cmd, "-worker")
+	}
+	if opts.readyPath != "" {
+		cmd = append(cmd, "-ready-path="+opts.readyPath)
+		if opts.readyInterval > 0 {
+			cmd = append(cmd, "-ready-interval="+opts.readyInterval.String())
+		}
+		if opts.readyTimeout > 0 {
+			cmd = append(cmd, "-ready-timeout="+opts.readyTimeout.String())
+		}
 	}
 	sw := new(strings.Builder)
-	fmt.Fprintf(sw, "ADD %s /bin/dumb_init\n", dumbInitURL)
-	fmt.Fprintf(sw, "ADD %s /bin/rundevd\n", rundevdURL)
-	fmt.Fprintln(sw, "RUN chmod +x /bin/rundevd /bin/dumb_init")
-	fmt.Fprintln(sw, `ENTRYPOINT ["/bin/dumb_init", "--"]`)
+	fmt.Fprintf(sw, "ADD %s /bin/rundevd\n", opts.rundevdURL)
+	fmt.Fprintln(sw, "RUN chmod +x /bin/rundevd")
+	fmt.Fprintln(sw, `ENTRYPOINT []`) // rundevd acts as the init process (PID 1)
 	fmt.Fprintf(sw, `CMD [`)
 	for i, a := range cmd {
 		fmt.Fprintf(sw, "%q", a)
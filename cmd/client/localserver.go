@@ -16,18 +16,24 @@ package main
 
 import (
 	"fmt"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/fsutil"
 	"github.com/ahmetb/rundev/lib/handlerutil"
+	"github.com/ahmetb/rundev/lib/types"
 	"github.com/kr/pretty"
 	"github.com/pkg/errors"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"runtime"
+	"time"
 )
 
 type localServerOpts struct {
 	sync        *syncer
 	proxyTarget string
+	logs        *logFollower // nil if not following the logs
 }
 
 type localServer struct {
@@ -37,13 +43,21 @@ type localServer struct {
 func newLocalServer(opts localServerOpts) (http.Handler, error) {
 	ls := &localServer{opts: opts}
 
-	reverseProxy, err := newReverseProxyHandler(opts.proxyTarget, ls.opts.sync)
+	var onInstance func(string)
+	if opts.logs != nil {
+		onInstance = opts.logs.seen
+	}
+	reverseProxy, err := newReverseProxyHandler(opts.proxyTarget, ls.opts.sync, onInstance)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to initialize reverse proxy")
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/rundev/fsz", handlerutil.NewFSDebugHandler(ls.opts.sync.opts.localDir, ls.opts.sync.opts.ignores))
+	var roots []handlerutil.FSRoot
+	for _, m := range ls.opts.sync.opts.mounts {
+		roots = append(roots, handlerutil.FSRoot{Name: m.mount.Remote, Dir: m.localDir, Ignores: m.ignores})
+	}
+	mux.HandleFunc("/rundev/fsz", handlerutil.NewFSDebugHandler(roots))
 	mux.HandleFunc("/rundev/debugz", ls.debugHandler)
 	mux.HandleFunc("/rundev/", handlerutil.NewUnsupportedDebugEndpointHandler())
 	mux.HandleFunc("/favicon.ico", handlerutil.NewUnsupportedDebugEndpointHandler()) // TODO(ahmetb) annoyance during testing on browser
@@ -52,28 +66,73 @@ func newLocalServer(opts localServerOpts) (http.Handler, error) {
 	return mux, nil
 }
 
-func newReverseProxyHandler(addr string, sync *syncer) (http.Handler, error) {
+// newReverseProxyHandler returns a handler proxying requests to the rundevd
+// at addr after syncing, calling onInstance (if not nil) with the rundevd
+// instance serving each request.
+func newReverseProxyHandler(addr string, sync *syncer, onInstance func(instance string)) (http.Handler, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to parse remote addr as url %s", addr)
 	}
 	rp := httputil.NewSingleHostReverseProxy(u)
-	rp.Transport = withSyncingRoundTripper(rp.Transport, sync, u.Host)
+	rp.FlushInterval = time.Millisecond * 100 // stream the "app is starting" page
+	rp.Transport = withSyncingRoundTripper(rp.Transport, sync, u.Host, onInstance)
 	return rp, nil
 }
 
+// debugHandler responds with the status of the client as text, or as JSON
+// (types.ClientStatus) with ?format=json or Accept: application/json.
 func (srv *localServer) debugHandler(w http.ResponseWriter, req *http.Request) {
+	if handlerutil.WantsJSON(req) {
+		st, err := srv.status()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "failed to fetch local filesystem: %+v", err)
+			return
+		}
+		handlerutil.WriteJSON(w, st)
+		return
+	}
 	checksum, err := srv.opts.sync.checksum()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Errorf("failed to fetch local filesystem: %+v", err)
+		fmt.Fprintf(w, "failed to fetch local filesystem: %+v", err)
+		return
 	}
 	fmt.Fprintf(w, "fs checksum: %v\n", checksum)
 	fmt.Fprintf(w, "pid: %d\n", os.Getpid())
 	wd, _ := os.Getwd()
 	fmt.Fprintf(w, "cwd: %s\n", wd)
 	fmt.Fprint(w, "sync:\n")
-	fmt.Fprintf(w, "  dir: %# v\n", pretty.Formatter(srv.opts.sync.opts.localDir))
 	fmt.Fprintf(w, "  target: %# v\n", pretty.Formatter(srv.opts.sync.opts.targetAddr))
-	fmt.Fprintf(w, "  ignores: %# v\n", pretty.Formatter(srv.opts.sync.opts.ignores))
+	fmt.Fprintln(w, "  dirs:")
+	for _, m := range srv.opts.sync.opts.mounts {
+		fmt.Fprintf(w, "  -> %s => %s (ignores: %# v)\n", m.localDir, m.mount.Remote, pretty.Formatter(m.ignores))
+	}
+}
+
+// status returns the status of the client served by /rundev/debugz as JSON.
+func (srv *localServer) status() (types.ClientStatus, error) {
+	fs, err := srv.opts.sync.walk()
+	if err != nil {
+		return types.ClientStatus{}, err
+	}
+	wd, _ := os.Getwd()
+	out := types.ClientStatus{
+		Version:   constants.Version,
+		GoVersion: runtime.Version(),
+		Pid:       os.Getpid(),
+		Cwd:       wd,
+		Checksum:  fsutil.CombinedChecksum(fs),
+		Target:    srv.opts.sync.opts.targetAddr,
+	}
+	for i, m := range srv.opts.sync.opts.mounts {
+		out.Mounts = append(out.Mounts, types.MountStatus{
+			Local:    m.localDir,
+			Remote:   m.mount.Remote,
+			Checksum: fs[i].RootChecksum(),
+			Ignores:  m.mount.Ignores,
+		})
+	}
+	return out, nil
 }
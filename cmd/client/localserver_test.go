@@ -0,0 +1,55 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/ahmetb/rundev/lib/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	srv := &localServer{opts: localServerOpts{sync: newSyncer(syncOpts{
+		mounts:     []syncMount{{localDir: tmp, mount: types.Mount{Local: ".", Remote: "/app"}}},
+		targetAddr: "http://rundevd",
+	})}}
+
+	w := httptest.NewRecorder()
+	srv.debugHandler(w, httptest.NewRequest(http.MethodGet, "/rundev/debugz", nil))
+	if !strings.HasPrefix(w.Body.String(), "fs checksum: ") {
+		t.Fatalf("expected text response, got:\n%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	srv.debugHandler(w, httptest.NewRequest(http.MethodGet, "/rundev/debugz?format=json", nil))
+	var st types.ClientStatus
+	if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Target != "http://rundevd" || st.Pid != os.Getpid() || len(st.Mounts) != 1 ||
+		st.Mounts[0].Local != tmp || st.Mounts[0].Remote != "/app" || st.Checksum == 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	followRetryDelay = time.Second * 2
+	lifecycleStream  = "lifecycle"
+)
+
+// logFollower prints the build output, and the output and lifecycle events of
+// the processes streamed from rundevd.
+type logFollower struct {
+	addr         string
+	clientSecret string
+	out          io.Writer
+
+	mu           sync.Mutex
+	instance     string // rundevd instance being followed
+	lastServed   string // rundevd instance that served the last request
+	cancelStream context.CancelFunc
+}
+
+func newLogFollower(addr, clientSecret string, out io.Writer) *logFollower {
+	return &logFollower{addr: addr, clientSecret: clientSecret, out: out}
+}
+
+// run follows the logs written after since until ctx is done, reconnecting
+// when the stream ends, e.g. when the rundevd instance is shut down.
+func (f *logFollower) run(ctx context.Context, since time.Time) {
+	for {
+		err := f.stream(ctx, &since)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("[logs] reconnecting to the log stream: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(followRetryDelay):
+		}
+	}
+}
+
+// seen is called with the rundevd instance serving a request. If requests
+// switch to another instance than the one being followed, the logs of the new
+// instance are followed.
+func (f *logFollower) seen(instance string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if instance == f.lastServed {
+		return
+	}
+	f.lastServed = instance
+	if f.instance != "" && f.instance != instance && f.cancelStream != nil {
+		log.Printf("[logs] requests are served by a new rundevd instance, following its logs")
+		f.cancelStream()
+	}
+}
+
+// stream prints the lines written after since, and advances since to the time
+// of the last line printed.
+func (f *logFollower) stream(ctx context.Context, since *time.Time) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f.mu.Lock()
+	f.cancelStream = cancel
+	f.mu.Unlock()
+
+	u := f.addr + "/rundevd/follow?since=" + url.QueryEscape(since.Format(time.RFC3339Nano))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create log stream request")
+	}
+	req.Header.Set(constants.HdrRundevClientSecret, f.clientSecret)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "error making log stream request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("unexpected log stream response status=%d. response body: %s", resp.StatusCode, string(b))
+	}
+	instance := resp.Header.Get(constants.HdrRundevIncarnation)
+	f.mu.Lock()
+	if f.instance != "" && f.instance != instance {
+		log.Printf("[logs] following the logs of rundevd instance %s", instance)
+	}
+	f.instance = instance
+	f.mu.Unlock()
+
+	d := json.NewDecoder(resp.Body)
+	for {
+		var l types.LogLine
+		if err := d.Decode(&l); err == io.EOF || ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "failed to decode log line")
+		}
+		fmt.Fprintln(f.out, formatLogLine(l))
+		*since = l.Time
+	}
+}
+
+// formatLogLine prefixes the line with its source, and formats lifecycle
+// events.
+func formatLogLine(l types.LogLine) string {
+	text := l.Text
+	if l.Stream == lifecycleStream {
+		text = fmt.Sprintf("--> process #%d %s", l.Incarnation, l.Text)
+	}
+	return fmt.Sprintf("%-8s| %s", l.Source, text)
+}
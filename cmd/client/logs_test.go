@@ -0,0 +1,92 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFormatLogLine(t *testing.T) {
+	tests := []struct {
+		in   types.LogLine
+		want string
+	}{
+		{types.LogLine{Source: "build", Stream: "stdout", Text: "compiling"}, "build   | compiling"},
+		{types.LogLine{Source: "web", Stream: "stderr", Incarnation: 2, Text: "panic"}, "web     | panic"},
+		{types.LogLine{Source: "worker", Stream: "lifecycle", Incarnation: 3, Text: "started pid=10 port=0"}, "worker  | --> process #3 started pid=10 port=0"},
+	}
+	for _, tt := range tests {
+		if got := formatLogLine(tt.in); got != tt.want {
+			t.Errorf("formatLogLine(%+v)=%q, want=%q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLogFollower_stream(t *testing.T) {
+	t0 := time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	var gotSince string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(constants.HdrRundevClientSecret) != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		gotSince = req.URL.Query().Get("since")
+		w.Header().Set(constants.HdrRundevIncarnation, "a")
+		e := json.NewEncoder(w)
+		e.Encode(types.LogLine{Source: "web", Stream: "stdout", Time: t0.Add(time.Second), Text: "hello"})
+		e.Encode(types.LogLine{Source: "web", Stream: "stdout", Time: t0.Add(time.Second * 2), Text: "world"})
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	f := newLogFollower(srv.URL, "secret", &out)
+	since := t0
+	if err := f.stream(context.Background(), &since); err != nil {
+		t.Fatal(err)
+	}
+	if gotSince != "2019-07-01T10:00:00Z" {
+		t.Fatalf("got since=%q", gotSince)
+	}
+	if diff := cmp.Diff("web     | hello\nweb     | world\n", out.String()); diff != "" {
+		t.Fatal(diff)
+	}
+	if !since.Equal(t0.Add(time.Second * 2)) {
+		t.Fatalf("since not advanced to the last line: %v", since)
+	}
+	if f.instance != "a" {
+		t.Fatalf("instance=%q", f.instance)
+	}
+}
+
+func TestLogFollower_seen(t *testing.T) {
+	f := newLogFollower("", "", nil)
+	canceled := 0
+	f.instance, f.cancelStream = "a", func() { canceled++ }
+	for _, inst := range []string{"a", "b", "b", "a"} {
+		f.seen(inst)
+	}
+	if canceled != 1 {
+		t.Fatalf("stream canceled %d times, expected once", canceled)
+	}
+}
@@ -19,15 +19,19 @@ import (
 	"flag"
 	"github.com/ahmetb/rundev/lib/dockerfile"
 	"github.com/ahmetb/rundev/lib/ignore"
+	"github.com/ahmetb/rundev/lib/signals"
 	"github.com/ahmetb/rundev/lib/types"
 	"github.com/google/shlex"
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -37,7 +41,23 @@ var (
 	flAddr       *string
 	flBuildCmd   *string
 	flRunCmd     *string
+	flProcfile   *string
+	flReload     reloadRules
+	flBuildArgs  = make(buildArgs)
+	flSyncDirs   stringsFlag
 	flNoCloudRun *bool
+	flRundevdURL *string
+	flEagerBuild *bool
+	flStopGrace  *time.Duration
+	flRestart    *string
+	flBlueGreen  *bool
+	flWorker     *bool
+	flSyncEvery  *time.Duration
+	flLogs       *bool
+
+	flReadyPath     *string
+	flReadyInterval *time.Duration
+	flReadyTimeout  *time.Duration
 
 	flCloudRunName            *string
 	flCloudRunCluster         *string
@@ -55,22 +75,42 @@ const (
 func init() {
 	log.SetFlags(log.Lmicroseconds)
 
-	flLocalDir = flag.String("local-dir", ".", "local directory to sync")
-	flRemoteDir = flag.String("remote-dir", "", "remote directory to sync (inside the container), defaults to container's WORKDIR")
+	flLocalDir = flag.String("local-dir", ".", "local directory with the Dockerfile (build context) to sync")
+	flRemoteDir = flag.String("remote-dir", "", "remote directory to sync -local-dir to (inside the container), inferred from COPY/ADD directives in Dockerfile by default")
+	flag.Var(&flSyncDirs, "sync", "(optional, repeatable) LOCAL_DIR:REMOTE_DIR directories to sync, inferred from COPY/ADD directives in Dockerfile by default")
 	flAddr = flag.String("addr", "localhost:8080", "network address to start the local proxy server")
 	flBuildCmd = flag.String("build-cmd", "", "(optional) command to re-build code (inside the container) after syncing,"+
 		"inferred from Dockerfile by default (add comment on RUN directives like #rundev")
-	flRunCmd = flag.String("run-cmd", "", "(optional) command to start application (inside the container) after syncing, inferred from Dockerfile by default")
+	flRunCmd = flag.String("run-cmd", "", "(optional) command to start application (inside the container) after syncing, inferred from Dockerfile by default (add comment on CMD/ENTRYPOINT directives like #rundev-run: CMD)")
+	flProcfile = flag.String("procfile", "Procfile", "(optional) file in -local-dir listing processes to run along with the app (e.g. workers, asset watchers) in NAME: COMMAND format, "+
+		"the web process is started from the Dockerfile. processes start with rundevd and are restarted when files matching their "+
+		"# rundev[PATTERN,..] comment change, processes without patterns (e.g. watchers) keep running across changes")
+	flag.Var(&flReload, "reload", "(optional, repeatable) ACTION:PATTERN[,PATTERN..] how the app picks up changes to the matching files, "+
+		"ACTION is none, signal (SIGHUP, or signal=SIGNAL), restart (without running build cmds) or rebuild (default)")
+	flag.Var(flBuildArgs, "build-arg", "(optional, repeatable) KEY=VALUE build-time variable passed to docker build and used while parsing the Dockerfile")
 
+	flEagerBuild = flag.Bool("eager-build", false, "(optional) rebuild and restart the app right after syncing files, instead of on the next request")
+	flStopGrace = flag.Duration("stop-grace-period", time.Second*3, "(optional) time to wait for the app to exit after the stop signal (STOPSIGNAL in Dockerfile, or SIGTERM) before killing it on restarts")
+	flRestart = flag.String("restart-policy", "never", "(optional) restart the app when it exits on its own: never (restart on next request), on-failure or always")
+	flBlueGreen = flag.Bool("blue-green", false, "(optional) keep the previous version of the app serving until the new version builds and starts listening")
+	flWorker = flag.Bool("worker", false, "(optional) the app doesn't serve HTTP (e.g. a queue consumer): start it on deploy and after every sync without waiting for it to listen on $PORT")
+	flSyncEvery = flag.Duration("sync-interval", 0, "(optional) how often to check for local changes and sync them without waiting for a request (default: 1s with -worker, otherwise only on requests)")
+	flLogs = flag.Bool("logs", true, "(optional) print the build output and the logs of the app streamed from the container")
+	flReadyPath = flag.String("ready-path", "", "(optional) HTTP path polled until it succeeds to consider the app ready, instead of waiting for it to listen on $PORT, "+
+		"inferred from HEALTHCHECK in Dockerfile (e.g. curl -f http://localhost:$PORT/healthz) by default")
+	flReadyInterval = flag.Duration("ready-interval", 0, "(optional) time between the requests to -ready-path (default: 200ms)")
+	flReadyTimeout = flag.Duration("ready-timeout", 0, "(optional) time to wait for -ready-path to succeed (default: 30s)")
 	flNoCloudRun = flag.Bool("no-cloudrun", false, "do not deploy to Cloud Run (you should start rundevd on localhost:8888)")
+	flRundevdURL = flag.String("rundevd-url", "", "(optional) URL of the rundevd binary added to the image (default: the release matching the client version)")
 	flCloudRunName = flag.String("name", appName, "name of the Cloud Run service")
 	flCloudRunPlatform = flag.String("platform", "managed", "(passthrough to gcloud) managed or gke")
 	flCloudRunCluster = flag.String("cluster", "", "(passthrough to gcloud) required when -platform=gke")
 	flCloudRunClusterLocation = flag.String("cluster-location", "", "(passthrough to gcloud) required when -platform=gke")
-	flag.Parse()
 }
 
 func main() {
+	flag.Parse()
+	start := time.Now()
 	clientSecret := uuid.New().String()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -87,6 +127,13 @@ func main() {
 		log.Fatalf("-local-dir (%s) is not a directory (%s)", *flLocalDir, fi.Mode())
 	}
 
+	if *flWorker && *flBlueGreen {
+		log.Fatal("-worker can't be used with -blue-green")
+	}
+	if *flSyncEvery < 0 {
+		log.Fatal("-sync-interval can't be negative")
+	}
+
 	if *flCloudRunPlatform == "" {
 		log.Fatal("-platform is empty")
 	} else if *flCloudRunPlatform != cloudRunManagedPlatform {
@@ -96,7 +143,6 @@ func main() {
 			log.Fatal("-cluster-location is empty, must be supplied when -platform is specified")
 		}
 	}
-	var fileIgnores *ignore.FileIgnores
 	var ignoreRules []string
 	if f, err := os.Open(filepath.Join(*flLocalDir, ".dockerignore")); err == nil {
 		defer f.Close()
@@ -104,7 +150,6 @@ func main() {
 		if err != nil {
 			log.Fatalf("failed to parse .dockerignore: %+v", err)
 		}
-		fileIgnores = ignore.NewFileIgnores(ignoreRules)
 		log.Printf("[info] parsed %d rules from .dockerignore file", len(ignoreRules))
 	} else if os.IsNotExist(err) {
 		log.Printf("if there are files you don't want to sync, you can create a .dockerignore file")
@@ -112,6 +157,22 @@ func main() {
 		log.Fatalf("failed attempt to read .dockerignore file: %+v", err)
 	}
 
+	mounts := types.Mounts{{Local: ".", Remote: *flRemoteDir}}
+	if len(flSyncDirs) > 0 {
+		if *flRemoteDir != "" {
+			log.Fatal("-remote-dir and -sync cannot be specified together")
+		}
+		mounts = nil
+		for _, v := range flSyncDirs {
+			m, err := parseMountFlag(*flLocalDir, v)
+			if err != nil {
+				log.Fatalf("failed to parse -sync: %+v", err)
+			}
+			mounts = append(mounts, m)
+		}
+	}
+
+	var syncMounts []syncMount
 	var rundevdURL string
 	if *flNoCloudRun {
 		rundevdURL = localRundevdURL
@@ -120,6 +181,14 @@ func main() {
 		if *flCloudRunName == "" {
 			log.Fatal("-name is empty")
 		}
+		daemonBinURL := *flRundevdURL
+		if daemonBinURL == "" {
+			u, err := rundevdReleaseURL()
+			if err != nil {
+				log.Fatal(err)
+			}
+			daemonBinURL = u
+		}
 		log.Printf("starting one-time \"build & push & deploy\" to Cloud Run")
 		project, err := currentProject(ctx)
 		if err != nil {
@@ -138,9 +207,10 @@ func main() {
 		if err != nil {
 			log.Fatalf("failed to parse Dockerfile: %+v", err)
 		}
+		d.SetBuildArgs(flBuildArgs)
 		var runCmd dockerfile.Cmd
 		if *flRunCmd == "" {
-			runCmd, err = dockerfile.ParseEntrypoint(d)
+			runCmd, err = dockerfile.ParseDevEntrypoint(d)
 			if err != nil {
 				log.Fatalf("failed to parse entrypoint/cmd from dockerfile. try specifying -run-cmd? error: %+v", err)
 			}
@@ -150,12 +220,15 @@ func main() {
 			if err != nil {
 				log.Fatalf("failed to parse -run-cmd into commands and args: %+v", err)
 			}
-			runCmd = dockerfile.Cmd{v[0], v[1:]}
+			runCmd = dockerfile.Cmd{Cmd: v[0], Args: v[1:]}
 		}
 
 		var buildCmds types.BuildCmds
 		if *flBuildCmd == "" {
-			v := dockerfile.ParseBuildCmds(d)
+			v, err := dockerfile.ParseBuildCmds(d)
+			if err != nil {
+				log.Fatalf("failed to parse #rundev build cmds from dockerfile: %+v", err)
+			}
 			if len(v) == 0 {
 				log.Printf("[info] -build-cmd not specified: if you have steps to build your code after syncing, use this flag, or add #rundev comment to RUN statements in your Dockerfile")
 			} else {
@@ -175,12 +248,75 @@ func main() {
 			}
 		}
 
+		var workDir string
+		if *flRemoteDir == "" {
+			if workDir = dockerfile.ParseWorkdir(d); workDir == "" {
+				workDir = "." // container's WORKDIR (inherited from base image)
+			}
+		}
+		if len(flSyncDirs) == 0 && *flRemoteDir == "" {
+			if v := inferMounts(dirMappings(*flLocalDir, dockerfile.ParseCopyMappings(d))); len(v) > 0 {
+				mounts = v
+				covered := false
+				for _, m := range mounts {
+					log.Printf("[info] syncing %s to %s (inferred from Dockerfile)", m.Local, m.Remote)
+					covered = covered || m.Local == "."
+				}
+				if !covered {
+					log.Printf("[warn] no COPY/ADD directive in Dockerfile copies the entire -local-dir (%s) into the image, only the directories above will be synced", *flLocalDir)
+				}
+			} else {
+				log.Printf("[warn] no COPY/ADD directive in Dockerfile copies -local-dir (%s) into the image, syncing to container's WORKDIR. try specifying -remote-dir?", *flLocalDir)
+			}
+		}
+		syncMounts, err = newSyncMounts(*flLocalDir, mounts, ignoreRules)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		procs, err := readProcfile(filepath.Join(*flLocalDir, *flProcfile))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range procs {
+			log.Printf("[info] running process %q: %s", p.Name, p.C)
+		}
+
+		readyPath, readyInterval, readyTimeout := *flReadyPath, *flReadyInterval, *flReadyTimeout
+		if readyPath == "" {
+			if hc := dockerfile.ParseHealthcheck(d); hc != nil && !hc.OnPortEnv() {
+				log.Printf("[warn] ignoring HEALTHCHECK in Dockerfile, it doesn't request a URL on $PORT (where the readiness probe is sent), use -ready-path instead")
+			} else if hc != nil {
+				log.Printf("[info] using readiness probe GET %s (inferred from HEALTHCHECK in Dockerfile)", hc.Path)
+				readyPath = hc.Path
+			}
+		}
+
+		stopSignal := dockerfile.ParseStopSignal(d)
+		if _, err := signals.Parse(stopSignal); stopSignal != "" && err != nil {
+			log.Printf("[warn] ignoring STOPSIGNAL in Dockerfile, using SIGTERM: %v", err)
+			stopSignal = ""
+		}
+
 		ro := remoteRunOpts{
-			syncDir:      *flRemoteDir,
+			rundevdURL:   daemonBinURL,
+			mounts:       mountsOf(syncMounts),
+			workDir:      workDir,
 			runCmd:       runCmd.Flatten(),
 			buildCmds:    buildCmds,
+			procs:        procs,
+			reloadRules:  types.ReloadRules(flReload),
 			clientSecret: clientSecret,
-			ignoreRules:  ignoreRules, // TODO(ahmetb) use this
+			eagerBuild:   *flEagerBuild,
+			stopSignal:   stopSignal,
+			stopGrace:    *flStopGrace,
+			restart:      *flRestart,
+			blueGreen:    *flBlueGreen,
+			worker:       *flWorker,
+
+			readyPath:     readyPath,
+			readyInterval: readyInterval,
+			readyTimeout:  readyTimeout,
 		}
 		newEntrypoint := prepEntrypoint(ro)
 		log.Printf("[info] injecting to dockerfile:\n%s", regexp.MustCompile("(?m)^").ReplaceAllString(newEntrypoint, "\t"))
@@ -189,6 +325,7 @@ func main() {
 		bo := buildOpts{
 			dir:        *flLocalDir,
 			image:      imageName,
+			buildArgs:  flBuildArgs,
 			dockerfile: df}
 		log.Print("building and pushing docker image")
 		if err := dockerBuildPush(ctx, bo); err != nil {
@@ -210,15 +347,35 @@ func main() {
 		defer cleanupCloudRun(*flCloudRunName, project, runRegion, cleanupDeadline)
 		rundevdURL = appURL
 	}
+	if syncMounts == nil {
+		v, err := newSyncMounts(*flLocalDir, mounts, ignoreRules)
+		if err != nil {
+			log.Fatal(err)
+		}
+		syncMounts = v
+	}
 	sync := newSyncer(syncOpts{
-		localDir:     *flLocalDir,
+		mounts:       syncMounts,
 		targetAddr:   rundevdURL,
 		clientSecret: clientSecret,
-		ignores:      fileIgnores,
 	})
+	syncInterval := *flSyncEvery
+	if syncInterval == 0 && *flWorker {
+		syncInterval = time.Second
+	}
+	if syncInterval > 0 {
+		log.Printf("[info] syncing local changes every %v", syncInterval)
+		go sync.pushEvery(ctx, syncInterval)
+	}
+	var logs *logFollower
+	if *flLogs {
+		logs = newLogFollower(rundevdURL, clientSecret, os.Stdout)
+		go logs.run(ctx, start)
+	}
 	localServerHandler, err := newLocalServer(localServerOpts{
 		proxyTarget: rundevdURL,
 		sync:        sync,
+		logs:        logs,
 	})
 	if err != nil {
 		log.Fatalf("failed to initialize local server: %+v", err)
@@ -241,3 +398,72 @@ func main() {
 		}
 	}
 }
+
+// buildArgs is a repeatable flag of KEY=VALUE pairs, similar to docker build
+// --build-arg. Specifying only KEY reads the value from the environment.
+type buildArgs map[string]string
+
+func (b buildArgs) String() string {
+	var out []string
+	for k, v := range b {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+func (b buildArgs) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if kv[0] == "" {
+		return errors.Errorf("build arg name is empty in %q", v)
+	}
+	if len(kv) == 1 {
+		b[kv[0]] = os.Getenv(kv[0])
+		return nil
+	}
+	b[kv[0]] = kv[1]
+	return nil
+}
+
+// reloadRules is a repeatable flag of ACTION:PATTERN[,PATTERN..] values,
+// where ACTION can also be signal=SIGNAL.
+type reloadRules types.ReloadRules
+
+func (r *reloadRules) String() string {
+	var out []string
+	for _, v := range *r {
+		out = append(out, v.Action+":"+strings.Join(v.On, ","))
+	}
+	return strings.Join(out, " ")
+}
+
+func (r *reloadRules) Set(v string) error {
+	i := strings.Index(v, ":")
+	if i <= 0 || i == len(v)-1 {
+		return errors.Errorf("reload rule %q is not in ACTION:PATTERN[,PATTERN..] format", v)
+	}
+	rule := types.ReloadRule{Action: v[:i], On: strings.Split(v[i+1:], ",")}
+	if strings.HasPrefix(rule.Action, "signal=") {
+		rule.Action, rule.Signal = "signal", strings.TrimPrefix(rule.Action, "signal=")
+		if _, err := signals.Parse(rule.Signal); err != nil {
+			return errors.Wrapf(err, "invalid reload rule %q", v)
+		}
+	}
+	switch rule.Action {
+	case "none", "signal", "restart", "rebuild":
+	default:
+		return errors.Errorf("unknown reload action %q in %q", rule.Action, v)
+	}
+	*r = append(*r, rule)
+	return nil
+}
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
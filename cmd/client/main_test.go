@@ -0,0 +1,52 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func Test_reloadRules_Set(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    types.ReloadRule
+		wantErr bool
+	}{
+		{in: "none:static/**,**/*.css", want: types.ReloadRule{Action: "none", On: []string{"static/**", "**/*.css"}}},
+		{in: "signal:nginx.conf", want: types.ReloadRule{Action: "signal", On: []string{"nginx.conf"}}},
+		{in: "signal=SIGUSR2:*.conf", want: types.ReloadRule{Action: "signal", Signal: "SIGUSR2", On: []string{"*.conf"}}},
+		{in: "restart:added|modified:templates/**", want: types.ReloadRule{Action: "restart", On: []string{"added|modified:templates/**"}}},
+		{in: "signal=SIGRTMIN+3:*.conf", want: types.ReloadRule{Action: "signal", Signal: "SIGRTMIN+3", On: []string{"*.conf"}}},
+		{in: "signal=SIGFOO:*.conf", wantErr: true},
+		{in: "reboot:*", wantErr: true},
+		{in: "none", wantErr: true},
+		{in: ":*", wantErr: true},
+	}
+	for _, tt := range tests {
+		var r reloadRules
+		err := r.Set(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("Set(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if diff := cmp.Diff(tt.want, r[0]); diff != "" {
+			t.Errorf("Set(%q) diff (-want +got):\n%s", tt.in, diff)
+		}
+	}
+}
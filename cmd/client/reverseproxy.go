@@ -33,9 +33,10 @@ type syncingRoundTripper struct {
 	next       http.RoundTripper
 	maxRetries int
 	hostHdr    string
+	onInstance func(instance string) // may be nil
 }
 
-func withSyncingRoundTripper(next http.RoundTripper, sync *syncer, host string) http.RoundTripper {
+func withSyncingRoundTripper(next http.RoundTripper, sync *syncer, host string, onInstance func(string)) http.RoundTripper {
 	if next == nil {
 		next = http.DefaultTransport
 	}
@@ -43,7 +44,8 @@ func withSyncingRoundTripper(next http.RoundTripper, sync *syncer, host string)
 		next:       next,
 		sync:       sync,
 		maxRetries: 10,
-		hostHdr:    host}
+		hostHdr:    host,
+		onInstance: onInstance}
 }
 
 func (s *syncingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -79,6 +81,9 @@ func (s *syncingRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 		if err != nil {
 			return nil, err // TODO(ahmetb) returning err from roundtrip method is not surfacing the error message in the response body, and prints a log to stderr by net/http's internal logger
 		}
+		if inst := resp.Header.Get(constants.HdrRundevIncarnation); inst != "" && s.onInstance != nil {
+			s.onInstance(inst)
+		}
 		ct := resp.Header.Get("content-type")
 		switch ct {
 		case constants.MimeProcessError:
@@ -93,7 +98,7 @@ func (s *syncingRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 			resp.Body.Close()
 			return &http.Response{
 				StatusCode: resp.StatusCode,
-				Body:       ioutil.NopCloser(strings.NewReader(fmt.Sprintf("process error: %s\n\noutput:\n%s", pe.Message, pe.Output))),
+				Body:       ioutil.NopCloser(strings.NewReader(formatProcError(pe))),
 			}, nil
 		case constants.MimeDumbRepeat:
 			// only for testing purposes
@@ -105,11 +110,14 @@ func (s *syncingRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to read remote fs in the response") // TODO mkErrorResp here
 			}
-			if err := s.sync.uploadPatch(remoteFS, remoteSum); err != nil {
+			if err := s.sync.uploadPatches(remoteFS); err != nil {
 				log.Printf("[retry %d] sync was failed: %v", retry, err)
 				continue
 			}
 		default:
+			if msg := resp.Header.Get(constants.HdrRundevSwapError); msg != "" {
+				log.Printf("[warn] new version failed to start, previous version served the request: %s", msg)
+			}
 			log.Printf("[reverse proxy] request completed on retry=%d path=%s status=%d took=%v (%s)", retry, req.URL.Path, resp.StatusCode, time.Since(start), resp.Header.Get("content-type"))
 			return resp, nil
 		}
@@ -121,3 +129,16 @@ func (s *syncingRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 			"please report an issue with console logs, /rundev/fsz and /rundevd/fsz responses.", s.maxRetries))),
 	}, nil
 }
+
+func formatProcError(pe types.ProcError) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "process error: %s\n", pe.Message)
+	if len(pe.Exits) > 0 {
+		fmt.Fprintln(&sb, "\nrecent exits:")
+		for _, e := range pe.Exits {
+			fmt.Fprintf(&sb, "  #%d: %s after %v\n", e.Incarnation, e.Status, e.Uptime.Round(time.Millisecond))
+		}
+	}
+	fmt.Fprintf(&sb, "\noutput:\n%s", pe.Output)
+	return sb.String()
+}
@@ -17,12 +17,20 @@ package main
 import (
 	"fmt"
 	"github.com/ahmetb/rundev/lib/constants"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 )
 
 func TestReverseProxy_transmitsChecksum(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
 	visits := 0
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		v := req.Header.Get(constants.HdrRundevChecksum)
@@ -37,10 +45,10 @@ func TestReverseProxy_transmitsChecksum(t *testing.T) {
 	defer srv.Close()
 
 	syncer := newSyncer(syncOpts{
-		localDir: "/Users/ahmetb/workspace/junk/py-hello", // TODO(ahmetb) create tempdir for test
+		mounts: []syncMount{{localDir: tmp}},
 	})
 
-	rp, err := newReverseProxyHandler(srv.URL, syncer)
+	rp, err := newReverseProxyHandler(srv.URL, syncer, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -61,6 +69,12 @@ func TestReverseProxy_transmitsChecksum(t *testing.T) {
 }
 
 func TestReverseProxy_repeatsRequest(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
 	i := 0
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		i++
@@ -72,9 +86,9 @@ func TestReverseProxy_repeatsRequest(t *testing.T) {
 	}))
 	defer srv.Close()
 	syncer := newSyncer(syncOpts{
-		localDir: "/Users/ahmetb/workspace/junk/py-hello", // TODO(ahmetb) create tempdir for test
+		mounts: []syncMount{{localDir: tmp}},
 	})
-	rp, err := newReverseProxyHandler(srv.URL, syncer)
+	rp, err := newReverseProxyHandler(srv.URL, syncer, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
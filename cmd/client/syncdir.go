@@ -0,0 +1,162 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/dockerfile"
+	"github.com/ahmetb/rundev/lib/ignore"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// dirMappings returns the mappings of directories in the build context
+// (localDir) that are copied into the image.
+func dirMappings(localDir string, mappings []dockerfile.PathMapping) []dockerfile.PathMapping {
+	var out []dockerfile.PathMapping
+	for _, m := range mappings {
+		fi, err := os.Stat(filepath.Join(localDir, filepath.FromSlash(m.Local)))
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		out = append(out, dockerfile.PathMapping{
+			Local:  m.Local,
+			Remote: m.Target(true),
+		})
+	}
+	return out
+}
+
+// inferMounts chooses the directories to sync from the directories copied
+// into the image, skipping the ones already synced as part of a parent
+// directory to the same location.
+func inferMounts(dirs []dockerfile.PathMapping) types.Mounts {
+	var out types.Mounts
+	for i, m := range dirs {
+		redundant := false
+		for j, o := range dirs {
+			if i != j && covers(o, m) && (!covers(m, o) || j > i) {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			out = append(out, types.Mount{Local: m.Local, Remote: m.Remote})
+		}
+	}
+	return out
+}
+
+// covers checks if the directory m is copied into its location in the
+// image as part of parent.
+func covers(parent, m dockerfile.PathMapping) bool {
+	if parent.Local == m.Local {
+		return parent.Remote == m.Remote
+	}
+	prefix := parent.Local + "/"
+	if parent.Local == "." {
+		prefix = ""
+	}
+	if !strings.HasPrefix(m.Local, prefix) {
+		return false
+	}
+	return path.Join(parent.Remote, strings.TrimPrefix(m.Local, prefix)) == m.Remote
+}
+
+// parseMountFlag parses a LOCAL_DIR:REMOTE_DIR value into a mount with a
+// local path relative to the build context (contextDir).
+func parseMountFlag(contextDir, v string) (types.Mount, error) {
+	i := strings.LastIndex(v, ":")
+	if i <= 0 || i == len(v)-1 {
+		return types.Mount{}, errors.Errorf("sync dir %q is not in LOCAL_DIR:REMOTE_DIR format", v)
+	}
+	local, remote := v[:i], v[i+1:]
+	rel, err := filepath.Rel(contextDir, local)
+	if err != nil {
+		return types.Mount{}, errors.Wrapf(err, "failed to find path of %s relative to %s", local, contextDir)
+	}
+	return types.Mount{Local: filepath.ToSlash(rel), Remote: remote}, nil
+}
+
+// newSyncMount initializes the exclusion rules of the mount. Directories in
+// the build context use the rules in the .dockerignore file of the context
+// (contextIgnores), and other directories use their own .dockerignore file.
+func newSyncMount(contextDir string, m types.Mount, contextIgnores []string) (syncMount, error) {
+	dir := filepath.Join(contextDir, filepath.FromSlash(m.Local))
+	if fi, err := os.Stat(dir); err != nil {
+		return syncMount{}, errors.Wrapf(err, "cannot open sync dir")
+	} else if !fi.IsDir() {
+		return syncMount{}, errors.Errorf("sync dir %s is not a directory (%s)", dir, fi.Mode())
+	}
+
+	if m.Local == ".." || strings.HasPrefix(m.Local, "../") {
+		rules, err := readDockerignore(dir)
+		if err != nil {
+			return syncMount{}, err
+		}
+		m.Ignores, m.IgnoreBase = rules, ""
+	} else {
+		m.Ignores, m.IgnoreBase = contextIgnores, m.Local
+		if m.Local == "." {
+			m.IgnoreBase = ""
+		}
+	}
+	return syncMount{
+		localDir: dir,
+		mount:    m,
+		ignores:  ignore.NewFileIgnores(m.Ignores).WithBase(m.IgnoreBase),
+	}, nil
+}
+
+// newSyncMounts initializes the mounts. Mounts with an empty remote directory
+// are synced to the container's WORKDIR.
+func newSyncMounts(contextDir string, mounts types.Mounts, contextIgnores []string) ([]syncMount, error) {
+	var out []syncMount
+	for _, m := range mounts {
+		if m.Remote == "" {
+			m.Remote = "."
+		}
+		v, err := newSyncMount(contextDir, m, contextIgnores)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func mountsOf(syncMounts []syncMount) types.Mounts {
+	var out types.Mounts
+	for _, m := range syncMounts {
+		out = append(out, m.mount)
+	}
+	return out
+}
+
+// readDockerignore parses the .dockerignore file in dir, if it exists.
+func readDockerignore(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed attempt to read .dockerignore file")
+	}
+	defer f.Close()
+	rules, err := ignore.ParseDockerignore(f)
+	return rules, errors.Wrapf(err, "failed to parse .dockerignore in %s", dir)
+}
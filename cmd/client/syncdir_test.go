@@ -0,0 +1,144 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/dockerfile"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_inferMounts(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.MkdirAll(filepath.Join(tmp, "src", "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "requirements.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		mappings []dockerfile.PathMapping
+		want     types.Mounts
+	}{
+		{
+			name: "no copies",
+		},
+		{
+			name: "only files",
+			mappings: []dockerfile.PathMapping{
+				{Local: "requirements.txt", Remote: "/app", ToDir: true},
+				{Local: "missing", Remote: "/app/missing"}},
+		},
+		{
+			name: "subdir copied as part of root dir",
+			mappings: []dockerfile.PathMapping{
+				{Local: "src", Remote: "/app/src"},
+				{Local: ".", Remote: "/app", ToDir: true},
+				{Local: "requirements.txt", Remote: "/app", ToDir: true}},
+			want: types.Mounts{{Local: ".", Remote: "/app"}},
+		},
+		{
+			name: "duplicate copies",
+			mappings: []dockerfile.PathMapping{
+				{Local: ".", Remote: "/app"},
+				{Local: ".", Remote: "/app"}},
+			want: types.Mounts{{Local: ".", Remote: "/app"}},
+		},
+		{
+			name: "subdirs to different locations",
+			mappings: []dockerfile.PathMapping{
+				{Local: ".", Remote: "/app"},
+				{Local: "src/pkg", Remote: "/pkg"},
+				{Local: "src", Remote: "/app/src"}},
+			want: types.Mounts{
+				{Local: ".", Remote: "/app"},
+				{Local: "src/pkg", Remote: "/pkg"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := inferMounts(dirMappings(tmp, tt.mappings))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("inferMounts() returned unexpected results:\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_parseMountFlag(t *testing.T) {
+	got, err := parseMountFlag("/src/repo", "/src/repo/service:/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(types.Mount{Local: "service", Remote: "/app"}, got); diff != "" {
+		t.Errorf("parseMountFlag() returned unexpected results:\n%s", diff)
+	}
+	got, err = parseMountFlag("/src/repo", "/src/shared-lib:/libs/shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(types.Mount{Local: "../shared-lib", Remote: "/libs/shared"}, got); diff != "" {
+		t.Errorf("parseMountFlag() returned unexpected results:\n%s", diff)
+	}
+	if _, err := parseMountFlag("/src/repo", "/src/repo/service"); err == nil {
+		t.Error("expected error for value without remote dir")
+	}
+}
+
+func Test_newSyncMount_ignores(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, d := range []string{"repo/service", "shared"} {
+		if err := os.MkdirAll(filepath.Join(tmp, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "shared", ".dockerignore"), []byte("*.tmp"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := filepath.Join(tmp, "repo")
+	m, err := newSyncMount(ctx, types.Mount{Local: "service", Remote: "/app"}, []string{"service/*.pyc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.ignores.Ignored("foo.pyc") {
+		t.Error("context ignore rules should apply relative to the mount")
+	}
+
+	m, err = newSyncMount(ctx, types.Mount{Local: "../shared", Remote: "/libs/shared"}, []string{"**/*.pyc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ignores.Ignored("foo.pyc") {
+		t.Error("context ignore rules should not apply outside the context")
+	}
+	if !m.ignores.Ignored("foo.tmp") {
+		t.Error("own .dockerignore rules should apply outside the context")
+	}
+}
@@ -15,23 +15,33 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/ahmetb/rundev/lib/constants"
 	"github.com/ahmetb/rundev/lib/fsutil"
 	"github.com/ahmetb/rundev/lib/ignore"
+	"github.com/ahmetb/rundev/lib/types"
 	"github.com/pkg/errors"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type syncOpts struct {
-	localDir     string
+	mounts       []syncMount
 	targetAddr   string
 	clientSecret string
-	ignores      *ignore.FileIgnores
+}
+
+// syncMount is a local directory synced to a directory in the container.
+type syncMount struct {
+	localDir string
+	mount    types.Mount
+	ignores  *ignore.FileIgnores
 }
 
 type syncer struct {
@@ -42,31 +52,120 @@ func newSyncer(opts syncOpts) *syncer {
 	return &syncer{opts: opts}
 }
 
+// walk walks the local directory trees of the mounts, in order.
+func (s *syncer) walk() ([]fsutil.FSNode, error) {
+	out := make([]fsutil.FSNode, 0, len(s.opts.mounts))
+	for _, m := range s.opts.mounts {
+		fs, err := fsutil.Walk(m.localDir, m.ignores)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to walk local fs dir %s", m.localDir)
+		}
+		out = append(out, fs)
+	}
+	return out, nil
+}
+
 func (s *syncer) checksum() (uint64, error) {
-	fs, err := fsutil.Walk(s.opts.localDir, s.opts.ignores)
+	fs, err := s.walk()
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to walk the local fs")
 	}
-	return fs.RootChecksum(), nil
+	return fsutil.CombinedChecksum(fs), nil
+}
+
+// push syncs the local directories to the remote without a proxied request,
+// by asking the remote for its directory trees and uploading patches until
+// the checksums match.
+func (s *syncer) push() error {
+	const maxRetries = 10
+	for retry := 0; retry < maxRetries; retry++ {
+		localChecksum, err := s.checksum()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodGet, s.opts.targetAddr+"/rundevd/sync", nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to create sync request")
+		}
+		req.Header.Set(constants.HdrRundevClientSecret, s.opts.clientSecret)
+		req.Header.Set(constants.HdrRundevChecksum, fmt.Sprintf("%d", localChecksum))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "error making sync request")
+		}
+		if resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			return nil
+		} else if resp.Header.Get("Content-Type") != constants.MimeChecksumMismatch {
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return errors.Errorf("unexpected sync response status=%d. response body: %s", resp.StatusCode, string(b))
+		}
+		remoteFS, err := parseMismatchResponse(resp.Body)
+		if err != nil {
+			return errors.Wrap(err, "failed to read remote fs in the response")
+		}
+		if err := s.uploadPatches(remoteFS); err != nil {
+			log.Printf("[retry %d] sync was failed: %v", retry, err)
+		}
+	}
+	return errors.Errorf("still getting a checksum mismatch after syncing %d times", maxRetries)
+}
+
+// pushEvery pushes the local directories to the remote every interval, until
+// ctx is done. The remote is asked for its checksum every time (rather than
+// only when the local files change), so a new rundevd instance that started
+// from the image gets the files too.
+func (s *syncer) pushEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := s.push(); err != nil {
+			log.Printf("[warn] failed to push sync: %+v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// uploadPatches uploads patches for the mounts that differ from the remote
+// directory trees.
+func (s *syncer) uploadPatches(remoteFS []fsutil.FSNode) error {
+	if len(remoteFS) != len(s.opts.mounts) {
+		return errors.Errorf("remote has %d sync dirs, local has %d", len(remoteFS), len(s.opts.mounts))
+	}
+	for i, m := range s.opts.mounts {
+		remoteChecksum := fmt.Sprintf("%d", remoteFS[i].RootChecksum())
+		if err := s.uploadPatch(i, m, remoteFS[i], remoteChecksum); err != nil {
+			return errors.Wrapf(err, "failed to sync %s", m.localDir)
+		}
+	}
+	return nil
 }
 
 // uploadPatch creates and uploads a patch to remote endpoint to be
-// applied if it's currently at the given checksum.
-func (s *syncer) uploadPatch(remoteFS fsutil.FSNode, currentRemoteChecksum string) error {
-	localFS, err := fsutil.Walk(s.opts.localDir, s.opts.ignores)
+// applied to the mount if it's currently at the given checksum.
+func (s *syncer) uploadPatch(idx int, m syncMount, remoteFS fsutil.FSNode, currentRemoteChecksum string) error {
+	localFS, err := fsutil.Walk(m.localDir, m.ignores)
 	if err != nil {
-		return errors.Wrapf(err, "failed to walk local fs dir %s", s.opts.localDir)
+		return errors.Wrapf(err, "failed to walk local fs dir %s", m.localDir)
 	}
 	localChecksum := localFS.RootChecksum()
+	if fmt.Sprintf("%d", localChecksum) == currentRemoteChecksum {
+		return nil // already in sync
+	}
 
-	log.Printf("checksum mismatch local=%d remote=%s", localChecksum, currentRemoteChecksum)
+	log.Printf("checksum mismatch for %s local=%d remote=%s", m.localDir, localChecksum, currentRemoteChecksum)
 	diff := fsutil.FSDiff(localFS, remoteFS)
 	log.Printf("diff operations (%d)", len(diff))
 	for _, v := range diff {
 		log.Printf("  %s", v)
 	}
 
-	tar, n, err := fsutil.PatchArchive(s.opts.localDir, diff, s.opts.ignores)
+	tar, n, err := fsutil.PatchArchive(m.localDir, diff, m.ignores)
 	if err != nil {
 		return err
 	}
@@ -79,6 +178,7 @@ func (s *syncer) uploadPatch(remoteFS fsutil.FSNode, currentRemoteChecksum strin
 	}
 	req.Header.Set("Content-Type", constants.MimePatch)
 	req.Header.Set(constants.HdrRundevClientSecret, s.opts.clientSecret)
+	req.Header.Set(constants.HdrRundevMount, strconv.Itoa(idx))
 	req.Header.Set(constants.HdrRundevPatchPreconditionSum, currentRemoteChecksum)
 	req.Header.Set(constants.HdrRundevChecksum, fmt.Sprintf("%d", localChecksum))
 	resp, err := http.DefaultClient.Do(req)
@@ -96,10 +196,11 @@ func (s *syncer) uploadPatch(remoteFS fsutil.FSNode, currentRemoteChecksum strin
 	return nil
 }
 
-// parseMismatchResponse decodes checksum mismatch response body which contains remote filesystem root node.
-func parseMismatchResponse(body io.ReadCloser) (fsutil.FSNode, error) {
+// parseMismatchResponse decodes checksum mismatch response body which contains remote filesystem root nodes
+// of each mount.
+func parseMismatchResponse(body io.ReadCloser) ([]fsutil.FSNode, error) {
 	defer body.Close()
-	var v fsutil.FSNode
+	var v []fsutil.FSNode
 	d := json.NewDecoder(body)
 	d.DisallowUnknownFields()
 	err := d.Decode(&v)
@@ -0,0 +1,84 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncerPush(t *testing.T) {
+	local, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(local)
+	if err := ioutil.WriteFile(filepath.Join(local, "a"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	remoteFS, err := fsutil.Walk(remote, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var syncs, patches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(constants.HdrRundevClientSecret) != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch req.URL.Path {
+		case "/rundevd/sync":
+			syncs++
+			if patches > 0 {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("Content-Type", constants.MimeChecksumMismatch)
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_ = json.NewEncoder(w).Encode([]fsutil.FSNode{remoteFS})
+		case "/rundevd/patch":
+			patches++
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	s := newSyncer(syncOpts{
+		mounts:       []syncMount{{localDir: local}},
+		targetAddr:   srv.URL,
+		clientSecret: "secret",
+	})
+	if err := s.push(); err != nil {
+		t.Fatal(err)
+	}
+	if syncs != 2 || patches != 1 {
+		t.Fatalf("got %d sync and %d patch requests, expected 2 and 1", syncs, patches)
+	}
+}
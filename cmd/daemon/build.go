@@ -0,0 +1,155 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// buildTracker keeps track of the in-flight build and the patches waiting to
+// be applied, so that builds of a tree that is about to change are canceled.
+type buildTracker struct {
+	mu      sync.Mutex
+	sums    []uint64 // checksums of the sync roots the in-flight build started from
+	cancel  context.CancelFunc
+	patches []*pendingPatch
+}
+
+type pendingPatch struct {
+	rootIdx int
+	sum     uint64 // checksum of the sync root after the patch
+}
+
+// start registers a new build of the tree fs. The returned context is canceled
+// if a patch changes the tree, and done must be called when the build is over.
+func (b *buildTracker) start(fs []fsutil.FSNode) (ctx context.Context, done func()) {
+	sums := make([]uint64, 0, len(fs))
+	for _, n := range fs {
+		sums = append(sums, n.RootChecksum())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b.mu.Lock()
+	b.sums, b.cancel = sums, cancel
+	if b.stale() {
+		log.Printf("[build] sync dirs are about to be patched, canceling build")
+		cancel()
+	}
+	b.mu.Unlock()
+	return ctx, func() {
+		b.mu.Lock()
+		b.sums, b.cancel = nil, nil
+		b.mu.Unlock()
+		cancel()
+	}
+}
+
+// patching registers a patch to the sync root that is waiting to be applied,
+// and cancels the in-flight build if it started from a different tree than
+// the one the root is patched to. done must be called when the patch is over.
+func (b *buildTracker) patching(rootIdx int, sum uint64) (done func()) {
+	p := &pendingPatch{rootIdx: rootIdx, sum: sum}
+	b.mu.Lock()
+	b.patches = append(b.patches, p)
+	if b.cancel != nil && b.stale() {
+		log.Printf("[build] canceling build of stale tree (sync dir #%d is patched to checksum %d)", rootIdx, sum)
+		b.cancel()
+	}
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, v := range b.patches {
+			if v == p {
+				b.patches = append(b.patches[:i], b.patches[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// stale checks if any of the pending patches changes the tree of the in-flight
+// build. It must be called while holding the lock.
+func (b *buildTracker) stale() bool {
+	for _, p := range b.patches {
+		if p.rootIdx < len(b.sums) && b.sums[p.rootIdx] != p.sum {
+			return true
+		}
+	}
+	return false
+}
+
+// runBuildCmd runs the build cmd to completion in its own process group, and
+// returns its combined output, which is also copied to progress as it's
+// written, if not nil. If ctx is done or the build cmd times out before it
+// exits, the whole process group is killed.
+func runBuildCmd(ctx context.Context, bc types.BuildCmd, workDir string, progress io.Writer) ([]byte, error) {
+	if bc.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bc.Timeout)
+		defer cancel()
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(bc.C.Command(), bc.C.Args()...)
+	cmd.Dir = buildDir(bc, workDir)
+	cmd.Env = append(os.Environ(), bc.Env...)
+	cmd.Stdout = &out
+	if progress != nil {
+		cmd.Stdout = io.MultiWriter(&out, progress)
+	}
+	cmd.Stderr = cmd.Stdout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to start build cmd")
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return out.Bytes(), err
+	case <-ctx.Done():
+		if !watchProcs(cmd.Process.Pid).kill(killWaitTimeout) {
+			log.Printf("[build] warning: build cmd processes still running after SIGKILL")
+		}
+		<-exited
+		if ctx.Err() == context.DeadlineExceeded {
+			return out.Bytes(), errors.Errorf("timed out after %v", bc.Timeout)
+		}
+		return out.Bytes(), ctx.Err()
+	}
+}
+
+// buildDir returns the directory the build cmd runs in.
+func buildDir(bc types.BuildCmd, workDir string) string {
+	if bc.Dir == "" {
+		return workDir
+	} else if filepath.IsAbs(bc.Dir) {
+		return bc.Dir
+	}
+	return filepath.Join(workDir, bc.Dir)
+}
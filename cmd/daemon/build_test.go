@@ -0,0 +1,105 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunBuildCmd_dirAndEnv(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Mkdir(tmp+"/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress logBuffer
+	b, err := runBuildCmd(context.Background(), types.BuildCmd{
+		C:   types.Cmd{"/bin/sh", "-c", `echo "$(basename "$PWD") $FOO"`},
+		Dir: "sub",
+		Env: []string{"FOO=bar"},
+	}, tmp, &progress)
+	if err != nil {
+		t.Fatalf("err=%v output=%s", err, b)
+	}
+	if got, want := strings.TrimSpace(string(b)), "sub bar"; got != want {
+		t.Fatalf("got output=%q, want=%q", got, want)
+	}
+	if progress.String() != string(b) {
+		t.Fatalf("progress=%q, expected the output", progress.String())
+	}
+}
+
+func TestRunBuildCmd_timeoutKillsProcessGroup(t *testing.T) {
+	start := time.Now()
+	b, err := runBuildCmd(context.Background(), types.BuildCmd{
+		C:       types.Cmd{"/bin/sh", "-c", "echo started; sleep 10 & wait"},
+		Timeout: 200 * time.Millisecond,
+	}, "", nil)
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("build cmd was not killed in time (took %v)", d)
+	}
+	if !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.TrimSpace(string(b)); got != "started" {
+		t.Fatalf("output not captured: %q", got)
+	}
+}
+
+func TestBuildTracker_cancelsStaleBuild(t *testing.T) {
+	var b buildTracker
+	fs := []fsutil.FSNode{{Name: "a"}, {Name: "b"}}
+	sum := fs[1].RootChecksum()
+
+	ctx, done := b.start(fs)
+	patchDone := b.patching(1, sum)
+	if ctx.Err() != nil {
+		t.Fatal("build canceled by a patch to the same tree")
+	}
+	patchDone()
+
+	patchDone = b.patching(1, sum+1)
+	if ctx.Err() != context.Canceled {
+		t.Fatal("build not canceled by a patch to a different tree")
+	}
+	done()
+
+	ctx, done = b.start(fs)
+	if ctx.Err() != context.Canceled {
+		t.Fatal("build started while the tree is being patched was not canceled")
+	}
+	done()
+	patchDone()
+
+	ctx, done = b.start(fs)
+	defer done()
+	if ctx.Err() != nil {
+		t.Fatal("build canceled after the patch is over")
+	}
+}
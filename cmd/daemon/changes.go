@@ -0,0 +1,120 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"sort"
+	"sync"
+)
+
+// pendingChanges accumulates the files changed by patches until the build
+// commands depending on them have succeeded. Changes added while a build is
+// running are kept for the next build, as the build may have missed them.
+type pendingChanges struct {
+	mu      sync.Mutex
+	gen     uint64                    // incremented by every add
+	changes map[string]*pendingChange // path -> change
+}
+
+type pendingChange struct {
+	typ     fsutil.ChangeType
+	gen     uint64       // of the last add of the file
+	waiting map[int]bool // indexes of build cmds waiting on the change
+}
+
+func newPendingChanges() *pendingChanges {
+	return &pendingChanges{changes: make(map[string]*pendingChange)}
+}
+
+// add records changed files, and marks the build cmds with conditions
+// matching them as pending.
+func (p *pendingChanges) add(changes []fsutil.Change, conds []buildCondition) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gen++
+	for _, c := range changes {
+		pc, ok := p.changes[c.Path]
+		if !ok {
+			pc = &pendingChange{typ: c.Type, waiting: make(map[int]bool)}
+			p.changes[c.Path] = pc
+		} else if !(pc.typ == fsutil.ChangeAdded && c.Type == fsutil.ChangeModified) {
+			// a file added and then modified is still a new file
+			pc.typ = c.Type
+		}
+		pc.gen = p.gen
+		for i, cond := range conds {
+			if len(cond) > 0 && cond.matches(c) {
+				pc.waiting[i] = true
+			}
+		}
+	}
+}
+
+// needs checks if any of the pending changes are waiting on the build cmd.
+func (p *pendingChanges) needs(cmdIdx int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pc := range p.changes {
+		if pc.waiting[cmdIdx] {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot returns the generation of the pending changes, taken when a build
+// starts.
+func (p *pendingChanges) snapshot() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gen
+}
+
+// done marks the build cmd as succeeded for the pending changes up to the
+// generation gen.
+func (p *pendingChanges) done(cmdIdx int, gen uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pc := range p.changes {
+		if pc.gen <= gen {
+			delete(pc.waiting, cmdIdx)
+		}
+	}
+}
+
+// reset clears the pending changes up to the generation gen, after all build
+// cmds have succeeded.
+func (p *pendingChanges) reset(gen uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for f, pc := range p.changes {
+		if pc.gen <= gen {
+			delete(p.changes, f)
+		}
+	}
+}
+
+// list returns the pending changes sorted by path.
+func (p *pendingChanges) list() []fsutil.Change {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]fsutil.Change, 0, len(p.changes))
+	for f, pc := range p.changes {
+		out = append(out, fsutil.Change{Path: f, Type: pc.typ})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
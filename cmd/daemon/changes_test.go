@@ -0,0 +1,104 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func mustConditions(t *testing.T, cmds types.BuildCmds) []buildCondition {
+	t.Helper()
+	c, err := parseBuildConditions(cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPendingChanges_accumulates(t *testing.T) {
+	cmds := types.BuildCmds{
+		{C: []string{"pip", "install"}, On: []string{"requirements.txt"}},
+		{C: []string{"make"}, On: []string{"**/*.py"}},
+	}
+	conds := mustConditions(t, cmds)
+	p := newPendingChanges()
+	p.add([]fsutil.Change{{Path: "requirements.txt", Type: fsutil.ChangeModified}}, conds)
+	p.add([]fsutil.Change{{Path: "app/main.py", Type: fsutil.ChangeAdded}}, conds)
+	p.add([]fsutil.Change{{Path: "app/main.py", Type: fsutil.ChangeModified}}, conds)
+
+	if !p.needs(0) {
+		t.Fatal("changes from the first patch were lost")
+	}
+	if !p.needs(1) {
+		t.Fatal("changes from the second patch were not recorded")
+	}
+	expected := []fsutil.Change{
+		{Path: "app/main.py", Type: fsutil.ChangeAdded},
+		{Path: "requirements.txt", Type: fsutil.ChangeModified},
+	}
+	if diff := cmp.Diff(expected, p.list()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestPendingChanges_survivesFailure(t *testing.T) {
+	cmds := types.BuildCmds{
+		{C: []string{"pip", "install"}, On: []string{"requirements.txt"}},
+		{C: []string{"make"}, On: []string{"requirements.txt"}},
+	}
+	p := newPendingChanges()
+	p.add([]fsutil.Change{{Path: "requirements.txt", Type: fsutil.ChangeModified}}, mustConditions(t, cmds))
+
+	gen := p.snapshot()
+	p.done(0, gen) // cmd 1 failed afterwards
+	if p.needs(0) {
+		t.Fatal("succeeded build cmd should not be pending")
+	}
+	if !p.needs(1) {
+		t.Fatal("failed build cmd should still be pending")
+	}
+	if len(p.list()) != 1 {
+		t.Fatalf("changed file should be pending: %v", p.list())
+	}
+
+	p.done(1, gen)
+	p.reset(gen)
+	if p.needs(1) || len(p.list()) != 0 {
+		t.Fatal("pending changes should be empty after all build cmds succeed")
+	}
+}
+
+func TestPendingChanges_addedDuringBuild(t *testing.T) {
+	cmds := types.BuildCmds{{C: []string{"make"}, On: []string{"**/*.go"}}}
+	conds := mustConditions(t, cmds)
+	p := newPendingChanges()
+	p.add([]fsutil.Change{{Path: "a.go", Type: fsutil.ChangeModified}}, conds)
+
+	gen := p.snapshot() // build starts
+	p.add([]fsutil.Change{{Path: "b.go", Type: fsutil.ChangeModified}}, conds)
+	p.done(0, gen)
+	p.reset(gen)
+
+	if !p.needs(0) {
+		t.Fatal("change added during the build should still need the build cmd")
+	}
+	expected := []fsutil.Change{{Path: "b.go", Type: fsutil.ChangeModified}}
+	if diff := cmp.Diff(expected, p.list()); diff != "" {
+		t.Fatal(diff)
+	}
+}
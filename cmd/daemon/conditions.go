@@ -0,0 +1,128 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/bmatcuk/doublestar"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// changeFilter is a single pattern of a build condition, in the form of
+// [!][TYPE|TYPE..:]GLOB, for example "modified:package-lock.json" or
+// "!vendor/**".
+type changeFilter struct {
+	exclude bool
+	types   map[fsutil.ChangeType]bool // nil matches any change type
+	glob    string
+}
+
+// buildCondition decides whether a changed file requires a build cmd to run.
+// A change matches if it matches any of the include filters and none of the
+// exclude filters.
+type buildCondition []changeFilter
+
+var changeTypes = map[string]fsutil.ChangeType{
+	fsutil.ChangeAdded.String():    fsutil.ChangeAdded,
+	fsutil.ChangeModified.String(): fsutil.ChangeModified,
+	fsutil.ChangeDeleted.String():  fsutil.ChangeDeleted,
+}
+
+func parseChangeFilter(s string) (changeFilter, error) {
+	var out changeFilter
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "!") {
+		out.exclude = true
+		s = strings.TrimSpace(s[1:])
+	}
+	if i := strings.Index(s, ":"); i > 0 {
+		types := make(map[fsutil.ChangeType]bool)
+		for _, v := range strings.Split(s[:i], "|") {
+			t, ok := changeTypes[strings.ToLower(strings.TrimSpace(v))]
+			if !ok {
+				// not a change type, treat the colon as part of the glob
+				types = nil
+				break
+			}
+			types[t] = true
+		}
+		if types != nil {
+			out.types = types
+			s = strings.TrimSpace(s[i+1:])
+		}
+	}
+	if s == "" {
+		return changeFilter{}, errors.New("empty file pattern")
+	}
+	if _, err := doublestar.Match(s, s); err != nil {
+		return changeFilter{}, errors.Wrapf(err, "invalid file pattern %q", s)
+	}
+	out.glob = s
+	return out, nil
+}
+
+// parseBuildCondition parses the file patterns of a build cmd. A condition
+// with only exclude patterns matches all other changes.
+func parseBuildCondition(patterns []string) (buildCondition, error) {
+	var out buildCondition
+	for _, p := range patterns {
+		f, err := parseChangeFilter(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse build condition %q", p)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// parseBuildConditions parses the conditions of each build cmd, in order.
+func parseBuildConditions(cmds types.BuildCmds) ([]buildCondition, error) {
+	out := make([]buildCondition, 0, len(cmds))
+	for i, bc := range cmds {
+		c, err := parseBuildCondition(bc.On)
+		if err != nil {
+			return nil, errors.Wrapf(err, "build cmd #%d", i)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func (f changeFilter) matches(c fsutil.Change) bool {
+	if f.types != nil && !f.types[c.Type] {
+		return false
+	}
+	ok, _ := doublestar.Match(f.glob, c.Path)
+	return ok
+}
+
+func (b buildCondition) matches(c fsutil.Change) bool {
+	included, hasIncludes := false, false
+	for _, f := range b {
+		if f.exclude {
+			if f.matches(c) {
+				return false
+			}
+			continue
+		}
+		hasIncludes = true
+		if !included && f.matches(c) {
+			included = true
+		}
+	}
+	return included || !hasIncludes
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"testing"
+)
+
+func TestBuildCondition_matches(t *testing.T) {
+	added := func(p string) fsutil.Change { return fsutil.Change{Path: p, Type: fsutil.ChangeAdded} }
+	modified := func(p string) fsutil.Change { return fsutil.Change{Path: p, Type: fsutil.ChangeModified} }
+	deleted := func(p string) fsutil.Change { return fsutil.Change{Path: p, Type: fsutil.ChangeDeleted} }
+
+	tests := []struct {
+		name     string
+		patterns []string
+		change   fsutil.Change
+		want     bool
+	}{
+		{"glob", []string{"**/*.go"}, modified("pkg/main.go"), true},
+		{"glob no match", []string{"**/*.go"}, modified("README.md"), false},
+		{"any of the globs", []string{"**/*.go", " go.*"}, modified("go.mod"), true},
+		{"type filter", []string{"modified:package-lock.json"}, modified("package-lock.json"), true},
+		{"type filter mismatch", []string{"modified:package-lock.json"}, added("package-lock.json"), false},
+		{"multiple types", []string{"added|deleted:**/*.go"}, deleted("main.go"), true},
+		{"deleted file", []string{"deleted:**/*.go"}, deleted("pkg/foo.go"), true},
+		{"exclude", []string{"**/*.go", "!vendor/**"}, modified("vendor/x/x.go"), false},
+		{"exclude not matching", []string{"**/*.go", "!vendor/**"}, modified("x/x.go"), true},
+		{"only excludes", []string{"!**/*.md"}, added("main.go"), true},
+		{"only excludes matching", []string{"!**/*.md"}, added("README.md"), false},
+		{"typed exclude", []string{"**", "!deleted:**"}, deleted("main.go"), false},
+		{"typed exclude other type", []string{"**", "!deleted:**"}, added("main.go"), true},
+		{"colon in glob", []string{"foo:bar"}, modified("foo:bar"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseBuildCondition(tt.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.matches(tt.change); got != tt.want {
+				t.Fatalf("matches(%v)=%v, want=%v", tt.change, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBuildCondition_invalid(t *testing.T) {
+	for _, p := range []string{"", "!", "deleted:", "[a-"} {
+		if _, err := parseBuildCondition([]string{p}); err == nil {
+			t.Fatalf("expected error for pattern %q", p)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/pkg/errors"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxEvents = 1000
+
+// eventLog keeps the recent events, and is safe for concurrent use. The zero
+// value is ready to use, and events emitted to a nil eventLog are dropped.
+type eventLog struct {
+	mu     sync.Mutex
+	events []types.Event // the last maxEvents
+	seq    int           // of the next event
+}
+
+// emit records the event, setting its sequence number and time.
+func (l *eventLog) emit(e types.Event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Seq, e.Time = l.seq, time.Now()
+	l.seq++
+	if len(l.events) == maxEvents {
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.events = append(l.events, e)
+}
+
+// since returns the events with a sequence number of at least seq, along with
+// the sequence number to read from next.
+func (l *eventLog) since(seq int) ([]types.Event, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []types.Event
+	for _, e := range l.events {
+		if e.Seq >= seq {
+			out = append(out, e)
+		}
+	}
+	return out, l.seq
+}
+
+// cmdExitCode returns the exit code of the failed command, or -1 if it didn't
+// exit on its own.
+func cmdExitCode(err error) int {
+	if ee, ok := err.(*exec.ExitError); ok {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
+// eventsHandler streams the events as JSON encoded types.Event values, one per
+// line, until the client disconnects. The recorded events starting from the
+// since query parameter (a sequence number) are sent first, otherwise only
+// new events are sent. The type query parameter (comma-separated) selects
+// the event types.
+func (srv *daemonServer) eventsHandler(w http.ResponseWriter, req *http.Request) {
+	_, next := srv.events.since(0)
+	if s := req.URL.Query().Get("since"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeErrorResp(w, http.StatusBadRequest, errors.Errorf("since=%q is not a non-negative number", s))
+			return
+		}
+		next = n
+	}
+	var eventTypes map[types.EventType]bool
+	if s := req.URL.Query().Get("type"); s != "" {
+		eventTypes = make(map[types.EventType]bool)
+		for _, t := range strings.Split(s, ",") {
+			eventTypes[types.EventType(t)] = true
+		}
+	}
+	w.Header().Set("Content-Type", constants.MimeEventStream)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(constants.HdrRundevIncarnation, srv.incarnation)
+	w.WriteHeader(http.StatusOK)
+
+	e := json.NewEncoder(w)
+	tick := time.NewTicker(followPollInterval)
+	defer tick.Stop()
+	for {
+		var events []types.Event
+		events, next = srv.events.since(next)
+		for _, ev := range events {
+			if eventTypes != nil && !eventTypes[ev.Type] {
+				continue
+			}
+			if err := e.Encode(ev); err != nil {
+				return
+			}
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		select {
+		case <-req.Context().Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func eventTypes(events []types.Event) []types.EventType {
+	var out []types.EventType
+	for _, e := range events {
+		out = append(out, e.Type)
+	}
+	return out
+}
+
+func TestEventLog(t *testing.T) {
+	var nilLog *eventLog
+	nilLog.emit(types.Event{Type: types.EventBuildStarted}) // dropped
+
+	l := new(eventLog)
+	for i := 0; i < maxEvents+2; i++ {
+		l.emit(types.Event{Type: types.EventPatchApplied})
+	}
+	all, next := l.since(0)
+	if len(all) != maxEvents || all[0].Seq != 2 || next != maxEvents+2 {
+		t.Fatalf("got %d events starting from seq=%d, next=%d", len(all), all[0].Seq, next)
+	}
+	if all[0].Time.IsZero() {
+		t.Fatal("event time not set")
+	}
+	if got, _ := l.since(maxEvents + 1); len(got) != 1 || got[0].Seq != maxEvents+1 {
+		t.Fatalf("unexpected events: %+v", got)
+	}
+}
+
+func TestEvent_firstMount(t *testing.T) {
+	mount := 0
+	b, err := json.Marshal(types.Event{Type: types.EventPatchApplied, Mount: &mount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"mount":0`) {
+		t.Fatalf("index of the first mount missing: %s", b)
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	srv := &daemonServer{events: new(eventLog)}
+	srv.events.emit(types.Event{Type: types.EventPatchApplied})
+	srv.events.emit(types.Event{Type: types.EventBuildStarted})
+	s := httptest.NewServer(http.HandlerFunc(srv.eventsHandler))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, s.URL+"?since=0&type=buildStarted,buildFinished", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	srv.events.emit(types.Event{Type: types.EventProcessStarted})
+	srv.events.emit(types.Event{Type: types.EventBuildFinished})
+
+	d := json.NewDecoder(resp.Body)
+	var got []types.Event
+	for len(got) < 2 {
+		var e types.Event
+		if err := d.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	want := []types.EventType{types.EventBuildStarted, types.EventBuildFinished}
+	if diff := cmp.Diff(want, eventTypes(got)); diff != "" {
+		t.Fatal(diff)
+	}
+	if got[1].Seq != 3 {
+		t.Fatalf("got seq=%d", got[1].Seq)
+	}
+}
+
+func TestBuild_events(t *testing.T) {
+	srv, _, tmp := testStartServer(t, "exit 3")
+	defer os.RemoveAll(tmp)
+	srv.events = new(eventLog)
+
+	if _, procErr := srv.build([]fsutil.FSNode{{Name: "a"}}); procErr == nil {
+		t.Fatal("expected build error")
+	}
+	events, _ := srv.events.since(0)
+	want := []types.EventType{types.EventBuildStarted, types.EventBuildFinished}
+	if diff := cmp.Diff(want, eventTypes(events)); diff != "" {
+		t.Fatal(diff)
+	}
+	if e := events[1]; e.ExitCode != 3 || e.Error == "" || e.Duration <= 0 {
+		t.Fatalf("unexpected build finished event: %+v", e)
+	}
+}
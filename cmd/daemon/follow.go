@@ -0,0 +1,114 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/types"
+	"net/http"
+	"time"
+)
+
+const (
+	buildLogSource     = "build"
+	followPollInterval = time.Millisecond * 250
+)
+
+// logSource is a log buffer streamed to the clients following the logs.
+type logSource struct {
+	name string
+	logs *logBuffer
+}
+
+// logSources returns the build logs, and the logs of the user process and the
+// named processes.
+func (srv *daemonServer) logSources() []logSource {
+	out := []logSource{{buildLogSource, srv.buildLogs}, {mainProcName, srv.procLogs}}
+	for _, p := range srv.procs {
+		out = append(out, logSource{p.Name, p.logs})
+	}
+	return out
+}
+
+// followHandler streams the build output and the output and lifecycle events
+// of the processes as JSON encoded types.LogLine values, one per line, until
+// the client disconnects. Lines are filtered with the stream and incarnation
+// query parameters. If since or tail is specified, the matching lines written
+// before the request are sent first (see parseLogQuery).
+func (srv *daemonServer) followHandler(w http.ResponseWriter, req *http.Request) {
+	q, err := parseLogQuery(req.URL.Query(), time.Now())
+	if err != nil {
+		writeErrorResp(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", constants.MimeLogStream)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(constants.HdrRundevIncarnation, srv.incarnation)
+	w.WriteHeader(http.StatusOK)
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	flush()
+
+	e := json.NewEncoder(w)
+	write := func(source string, lines []logLine) error {
+		for _, l := range lines {
+			if err := e.Encode(types.LogLine{
+				Source:      source,
+				Stream:      l.stream,
+				Incarnation: l.incarnation,
+				Time:        l.at,
+				Text:        l.text,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	sources := srv.logSources()
+	offs := make([]int, len(sources))
+	for i, s := range sources {
+		var lines []logLine
+		lines, offs[i] = s.logs.follow(0)
+		if q.since.IsZero() && q.tail == 0 {
+			continue
+		}
+		if err := write(s.name, q.apply(lines)); err != nil {
+			return
+		}
+	}
+	filter := logQuery{stream: q.stream, incarnation: q.incarnation}
+	tick := time.NewTicker(followPollInterval)
+	defer tick.Stop()
+	for {
+		for i, s := range sources {
+			var lines []logLine
+			lines, offs[i] = s.logs.follow(offs[i])
+			if err := write(s.name, filter.apply(lines)); err != nil {
+				return
+			}
+		}
+		flush()
+		select {
+		case <-req.Context().Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFollowHandler(t *testing.T) {
+	srv := &daemonServer{procLogs: new(logBuffer), buildLogs: new(logBuffer)}
+	srv.buildLogs.Write([]byte("b1\nb2\n"))
+	srv.procLogs.writer(streamStdout, 1).Write([]byte("p1\np2\n"))
+	s := httptest.NewServer(http.HandlerFunc(srv.followHandler))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, s.URL+"?tail=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	srv.procLogs.event(2, "started")
+	srv.procLogs.writer(streamStderr, 2).Write([]byte("p3\n"))
+
+	d := json.NewDecoder(resp.Body)
+	var got []types.LogLine
+	for len(got) < 4 {
+		var l types.LogLine
+		if err := d.Decode(&l); err != nil {
+			t.Fatal(err)
+		}
+		if l.Time.IsZero() {
+			t.Fatalf("line has no time: %+v", l)
+		}
+		l.Time = time.Time{}
+		got = append(got, l)
+	}
+	want := []types.LogLine{
+		{Source: buildLogSource, Stream: streamStdout, Text: "b2"},
+		{Source: mainProcName, Stream: streamStdout, Incarnation: 1, Text: "p2"},
+		{Source: mainProcName, Stream: streamLifecycle, Incarnation: 2, Text: "started"},
+		{Source: mainProcName, Stream: streamStderr, Incarnation: 2, Text: "p3"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// forwardedSignals are passed from the init process to the daemon.
+var forwardedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// runInit runs rundevd as the init process (PID 1) of the container, in place
+// of an init system like dumb-init. It starts the daemon as a child process,
+// forwards termination signals to it (which stops the user process before
+// exiting) and reaps the orphaned processes re-parented to PID 1. It returns
+// the exit code of the daemon.
+func runInit() int {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	sigCh := make(chan os.Signal, 16)
+	signal.Notify(sigCh, append(forwardedSignals, syscall.SIGCHLD)...)
+
+	proc, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Env:   os.Environ(),
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+	})
+	if err != nil {
+		log.Printf("[init] failed to start rundevd: %v", err)
+		return 1
+	}
+	log.Printf("[init] started rundevd as pid %d", proc.Pid)
+
+	for sig := range sigCh {
+		if sig == syscall.SIGCHLD {
+			if code, exited := reapChildren(proc.Pid); exited {
+				log.Printf("[init] rundevd exited with code %d", code)
+				return code
+			}
+			continue
+		}
+		log.Printf("[init] forwarding %v to rundevd", sig)
+		if err := proc.Signal(sig); err != nil {
+			log.Printf("[init] warning: failed to forward signal: %v", err)
+		}
+	}
+	return 1 // unreachable
+}
+
+// reapChildren collects the exit status of all exited child processes, and
+// returns the exit code of the daemon (pid) if it has exited.
+func reapChildren(pid int) (code int, exited bool) {
+	for {
+		var ws syscall.WaitStatus
+		p, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil || p <= 0 {
+			return code, exited
+		}
+		if p == pid {
+			code, exited = exitCode(ws), true
+		}
+	}
+}
+
+// exitCode converts the wait status into an exit code like shells do, where
+// termination by a signal is reported as 128+SIGNAL.
+func exitCode(ws syscall.WaitStatus) int {
+	switch {
+	case ws.Exited():
+		return ws.ExitStatus()
+	case ws.Signaled():
+		return 128 + int(ws.Signal())
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// inSubprocess runs the calling test again in a child process, and reports
+// whether the caller is that child. Tests of reapChildren run in a child, as
+// it reaps any exited child of the process, including those of other tests.
+func inSubprocess(t *testing.T) bool {
+	if os.Getenv("RUNDEVD_TEST_SUBPROCESS") == t.Name() {
+		return true
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), "RUNDEVD_TEST_SUBPROCESS="+t.Name())
+	out, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "--- PASS: "+t.Name()) {
+		t.Fatalf("%s failed in subprocess: %v\n%s", t.Name(), err, out)
+	}
+	return false
+}
+
+func TestReapChildren(t *testing.T) {
+	if !inSubprocess(t) {
+		return
+	}
+	orphan, err := os.StartProcess("/bin/sh", []string{"sh", "-c", "exit 0"}, &os.ProcAttr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := os.StartProcess("/bin/sh", []string{"sh", "-c", "exit 3"}, &os.ProcAttr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var code int
+	var exited bool
+	for i := 0; i < 100 && !exited; i++ {
+		time.Sleep(time.Millisecond * 10)
+		code, exited = reapChildren(child.Pid)
+	}
+	if !exited || code != 3 {
+		t.Fatalf("reapChildren()=%d,%v, want=3,true", code, exited)
+	}
+	if _, err := orphan.Wait(); err == nil {
+		t.Fatal("other child process was not reaped")
+	}
+}
+
+func TestReapChildren_signaled(t *testing.T) {
+	if !inSubprocess(t) {
+		return
+	}
+	child, err := os.StartProcess("/bin/sleep", []string{"sleep", "100"}, &os.ProcAttr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	var code int
+	var exited bool
+	for i := 0; i < 100 && !exited; i++ {
+		time.Sleep(time.Millisecond * 10)
+		code, exited = reapChildren(child.Pid)
+	}
+	if !exited || code != 128+9 {
+		t.Fatalf("reapChildren()=%d,%v, want=137,true", code, exited)
+	}
+}
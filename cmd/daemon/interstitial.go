@@ -0,0 +1,101 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	interstitialDelay        = time.Second // before browsers get the page instead of waiting
+	interstitialPollInterval = time.Millisecond * 250
+	interstitialMaxStream    = time.Second * 30 // before the page reloads to keep waiting
+)
+
+// acceptsHTML checks if the request is from a browser navigating to a page.
+func acceptsHTML(req *http.Request) bool {
+	return req.Method == http.MethodGet && strings.Contains(req.Header.Get("accept"), "text/html")
+}
+
+// writeStartingPage responds with a page streaming the build and startup logs
+// until the start op is over, after which the page reloads. If the op failed,
+// the error is shown instead.
+func (srv *daemonServer) writeStartingPage(w http.ResponseWriter, req *http.Request, op *startOp) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprint(w, startingPageHeader)
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	var buildOff, procOff int
+	writeLogs := func() {
+		var b []byte
+		b, buildOff = srv.buildLogs.since(buildOff)
+		io.WriteString(w, html.EscapeString(string(b)))
+		b, procOff = srv.procLogs.since(procOff)
+		io.WriteString(w, html.EscapeString(string(b)))
+		flush()
+	}
+
+	tick := time.NewTicker(interstitialPollInterval)
+	defer tick.Stop()
+	timeout := time.After(interstitialMaxStream)
+	for {
+		writeLogs()
+		select {
+		case <-req.Context().Done():
+			return
+		case <-timeout:
+			fmt.Fprint(w, startingPageReload)
+			return
+		case <-op.done:
+			writeLogs()
+			if op.procErr != nil && !op.notReady {
+				fmt.Fprintf(w, startingPageError, html.EscapeString(op.procErr.Message))
+				return
+			}
+			fmt.Fprint(w, startingPageReload)
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+const startingPageHeader = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Starting app&hellip;</title></head>
+<body style="font:14px sans-serif;margin:2em">
+<h2>rundev: your app is starting&hellip;</h2>
+<p>This page reloads when the app is ready.</p>
+<pre style="background:#f4f4f4;padding:1em;white-space:pre-wrap">`
+
+const startingPageReload = `</pre>
+<script>location.reload()</script>
+</body></html>
+`
+
+const startingPageError = `</pre>
+<p style="color:#c62828"><b>%s</b></p>
+<p>Fix the error and reload the page.</p>
+</body></html>
+`
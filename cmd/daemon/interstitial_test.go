@@ -0,0 +1,85 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptsHTML(t *testing.T) {
+	tests := []struct {
+		method string
+		accept string
+		want   bool
+	}{
+		{http.MethodGet, "text/html,application/xhtml+xml,*/*;q=0.8", true},
+		{http.MethodGet, "application/json", false},
+		{http.MethodGet, "", false},
+		{http.MethodPost, "text/html", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		req.Header.Set("Accept", tt.accept)
+		if got := acceptsHTML(req); got != tt.want {
+			t.Errorf("acceptsHTML(%s, %q) = %v, want %v", tt.method, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestWriteStartingPage(t *testing.T) {
+	tests := []struct {
+		name     string
+		procErr  *types.ProcError
+		notReady bool
+		want     string
+	}{
+		{name: "started", want: "location.reload()"},
+		{name: "not listening yet", procErr: &types.ProcError{Message: "timeout"}, notReady: true, want: "location.reload()"},
+		{name: "build failed", procErr: &types.ProcError{Message: "build <failed>"}, want: "build &lt;failed&gt;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &daemonServer{procLogs: new(logBuffer), buildLogs: new(logBuffer)}
+			op := &startOp{done: make(chan struct{})}
+			go func() {
+				srv.buildLogs.Write([]byte("compiling <main>\n"))
+				time.Sleep(interstitialPollInterval * 2)
+				srv.procLogs.Write([]byte("listening\n"))
+				op.procErr, op.notReady = tt.procErr, tt.notReady
+				close(op.done)
+			}()
+
+			w := httptest.NewRecorder()
+			srv.writeStartingPage(w, httptest.NewRequest(http.MethodGet, "/", nil), op)
+			body := w.Body.String()
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("got status %d", w.Code)
+			}
+			for _, s := range []string{"compiling &lt;main&gt;\n", "listening\n", tt.want} {
+				if !strings.Contains(body, s) {
+					t.Fatalf("page does not contain %q:\n%s", s, body)
+				}
+			}
+			if strings.Count(body, "compiling") != 1 {
+				t.Fatalf("logs repeated:\n%s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	streamStdout    = "stdout"
+	streamStderr    = "stderr"
+	streamLifecycle = "lifecycle" // process started, exited etc.
+
+	defaultMaxLogLines = 10000     // per process
+	keepIncarnations   = 5         // lines of older incarnations are dropped
+	maxLogLineLen      = 16 * 1024 // longer lines are split
+	logTimeFormat      = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// logLine is a line of output of a process.
+type logLine struct {
+	seq         int // increases with every line written to the buffer
+	at          time.Time
+	stream      string
+	incarnation int // of the process, 0 if written with logBuffer.Write
+	text        string
+}
+
+func (l logLine) String() string {
+	return fmt.Sprintf("%s %s #%d: %s", l.at.Format(logTimeFormat), l.stream, l.incarnation, l.text)
+}
+
+// logBuffer keeps the last lines of output of a process across its recent
+// incarnations, and is safe to read while they're writing to it. The zero
+// value is ready to use.
+type logBuffer struct {
+	maxLines int // defaultMaxLogLines if zero
+
+	mu     sync.Mutex
+	ring   []logLine // oldest line at head once full
+	head   int
+	seq    int // of the next line
+	latest int // incarnation
+	out    *logWriter
+}
+
+// logQuery selects lines from a logBuffer.
+type logQuery struct {
+	since       time.Time // lines written after, if not zero
+	stream      string    // all streams if empty
+	incarnation int       // all incarnations if zero
+	tail        int       // only the last n lines, if positive
+}
+
+// parseLogQuery parses the since, stream, incarnation and tail query
+// parameters. since is a timestamp in RFC 3339 format, or a duration before
+// now (e.g. 5m).
+func parseLogQuery(v url.Values, now time.Time) (logQuery, error) {
+	var q logQuery
+	if s := v.Get("since"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			q.since = now.Add(-d)
+		} else if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			q.since = t
+		} else {
+			return q, errors.Errorf("since=%q is neither a duration nor an RFC 3339 timestamp", s)
+		}
+	}
+	switch s := v.Get("stream"); s {
+	case "", streamStdout, streamStderr, streamLifecycle:
+		q.stream = s
+	default:
+		return q, errors.Errorf("unknown stream=%q (valid values: %s, %s, %s)", s, streamStdout, streamStderr, streamLifecycle)
+	}
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{{"incarnation", &q.incarnation}, {"tail", &q.tail}} {
+		if s := v.Get(p.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return q, errors.Errorf("%s=%q is not a non-negative number", p.name, s)
+			}
+			*p.v = n
+		}
+	}
+	return q, nil
+}
+
+// apply returns the lines selected by the query.
+func (q logQuery) apply(lines []logLine) []logLine {
+	var out []logLine
+	for _, l := range lines {
+		if q.matches(l) {
+			out = append(out, l)
+		}
+	}
+	if q.tail > 0 && len(out) > q.tail {
+		out = out[len(out)-q.tail:]
+	}
+	return out
+}
+
+func (q logQuery) matches(l logLine) bool {
+	return l.at.After(q.since) &&
+		(q.stream == "" || l.stream == q.stream) &&
+		(q.incarnation == 0 || l.incarnation == q.incarnation)
+}
+
+// writer returns a writer that adds the output written to it to the buffer
+// as lines of the stream of the process incarnation. Lines of the
+// incarnations before the last few are dropped once it's written to.
+func (l *logBuffer) writer(stream string, incarnation int) *logWriter {
+	return &logWriter{buf: l, stream: stream, incarnation: incarnation}
+}
+
+// Write adds the output to the buffer as stdout lines of incarnation 0, used
+// by build cmds.
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	if l.out == nil {
+		l.out = l.writer(streamStdout, 0)
+	}
+	w := l.out
+	l.mu.Unlock()
+	return w.Write(p)
+}
+
+// Flush adds the partial last line written with Write to the buffer.
+func (l *logBuffer) Flush() {
+	l.mu.Lock()
+	w := l.out
+	l.mu.Unlock()
+	if w != nil {
+		w.Flush()
+	}
+}
+
+// event adds a lifecycle event of the process incarnation to the buffer.
+func (l *logBuffer) event(incarnation int, text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(logLine{at: time.Now(), stream: streamLifecycle, incarnation: incarnation, text: text})
+}
+
+// add adds the line to the buffer, while holding the lock.
+func (l *logBuffer) add(line logLine) {
+	if line.incarnation > l.latest {
+		l.latest = line.incarnation
+		l.dropIncarnationsBefore(l.latest - keepIncarnations + 1)
+	}
+	line.seq = l.seq
+	l.seq++
+	max := l.maxLines
+	if max <= 0 {
+		max = defaultMaxLogLines
+	}
+	if len(l.ring) < max {
+		l.ring = append(l.ring, line)
+		return
+	}
+	l.ring[l.head] = line
+	l.head = (l.head + 1) % len(l.ring)
+}
+
+func (l *logBuffer) dropIncarnationsBefore(incarnation int) {
+	var kept []logLine
+	l.each(func(line logLine) {
+		if line.incarnation == 0 || line.incarnation >= incarnation {
+			kept = append(kept, line)
+		}
+	})
+	l.ring, l.head = kept, 0
+}
+
+// each calls fn for the lines from oldest to newest, while holding the lock.
+func (l *logBuffer) each(fn func(logLine)) {
+	for i := range l.ring {
+		fn(l.ring[(l.head+i)%len(l.ring)])
+	}
+}
+
+func (l *logBuffer) query(q logQuery) []logLine {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []logLine
+	l.each(func(line logLine) { out = append(out, line) })
+	return q.apply(out)
+}
+
+// output returns the text of the lines the incarnation has written.
+func (l *logBuffer) output(incarnation int) []byte {
+	return linesText(l.query(logQuery{incarnation: incarnation}))
+}
+
+// follow returns the lines added after the offset, along with the offset to
+// read from next.
+func (l *logBuffer) follow(off int) ([]logLine, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []logLine
+	l.each(func(line logLine) {
+		if line.seq >= off {
+			out = append(out, line)
+		}
+	})
+	return out, l.seq
+}
+
+// Bytes returns the output of the latest incarnation.
+func (l *logBuffer) Bytes() []byte {
+	l.mu.Lock()
+	latest := l.latest
+	l.mu.Unlock()
+	return l.output(latest)
+}
+
+// String returns the output of the latest incarnation.
+func (l *logBuffer) String() string { return string(l.Bytes()) }
+
+// Reset drops all lines.
+func (l *logBuffer) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ring, l.head = nil, 0
+}
+
+// since returns the output of the latest incarnation written after the
+// offset, along with the offset to read from next.
+func (l *logBuffer) since(off int) ([]byte, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []logLine
+	l.each(func(line logLine) {
+		if line.seq >= off && line.incarnation == l.latest && line.stream != streamLifecycle {
+			out = append(out, line)
+		}
+	})
+	return linesText(out), l.seq
+}
+
+// linesText returns the text of the lines, skipping lifecycle events.
+func linesText(lines []logLine) []byte {
+	var b bytes.Buffer
+	for _, l := range lines {
+		if l.stream == streamLifecycle {
+			continue
+		}
+		b.WriteString(l.text)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// writeLines writes the lines with their time, stream and incarnation.
+func writeLines(w io.Writer, lines []logLine) {
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+}
+
+// logWriter splits the output written to it into lines of a logBuffer.
+type logWriter struct {
+	buf         *logBuffer
+	stream      string
+	incarnation int
+	partial     []byte // guarded by buf.mu
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	w.buf.mu.Lock()
+	defer w.buf.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for len(w.partial) > 0 {
+		i := bytes.IndexByte(w.partial, '\n')
+		next := i + 1
+		if i < 0 || i > maxLogLineLen {
+			if len(w.partial) < maxLogLineLen {
+				break
+			}
+			i, next = maxLogLineLen, maxLogLineLen
+		}
+		w.buf.add(w.line(now, w.partial[:i]))
+		w.partial = w.partial[next:]
+	}
+	return len(p), nil
+}
+
+// Flush adds the partial last line to the buffer, called once the process
+// exits.
+func (w *logWriter) Flush() {
+	w.buf.mu.Lock()
+	defer w.buf.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.buf.add(w.line(time.Now(), w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *logWriter) line(at time.Time, text []byte) logLine {
+	return logLine{at: at, stream: w.stream, incarnation: w.incarnation, text: string(text)}
+}
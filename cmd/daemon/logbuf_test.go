@@ -0,0 +1,163 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func texts(lines []logLine) []string {
+	var out []string
+	for _, l := range lines {
+		out = append(out, fmt.Sprintf("%s #%d %s", l.stream, l.incarnation, l.text))
+	}
+	return out
+}
+
+func TestLogBuffer_lines(t *testing.T) {
+	l := new(logBuffer)
+	out, errs := l.writer(streamStdout, 1), l.writer(streamStderr, 1)
+	out.Write([]byte("hello\nwor"))
+	errs.Write([]byte("oops\n"))
+	out.Write([]byte("ld\npartial"))
+	if got, want := l.String(), "hello\noops\nworld\n"; got != want {
+		t.Fatalf("got=%q, want=%q", got, want)
+	}
+	out.Flush()
+	want := []string{"stdout #1 hello", "stderr #1 oops", "stdout #1 world", "stdout #1 partial"}
+	if diff := cmp.Diff(want, texts(l.query(logQuery{}))); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLogBuffer_splitsLongLines(t *testing.T) {
+	l := new(logBuffer)
+	b := make([]byte, maxLogLineLen+10)
+	for i := range b {
+		b[i] = 'x'
+	}
+	l.Write(append(b, '\n'))
+	lines := l.query(logQuery{})
+	if len(lines) != 2 || len(lines[0].text) != maxLogLineLen || len(lines[1].text) != 10 {
+		t.Fatalf("unexpected lines: %d", len(lines))
+	}
+}
+
+func TestLogBuffer_ring(t *testing.T) {
+	l := &logBuffer{maxLines: 3}
+	w := l.writer(streamStdout, 1)
+	w.Write([]byte("1\n2\n3\n4\n5\n"))
+	want := []string{"stdout #1 3", "stdout #1 4", "stdout #1 5"}
+	if diff := cmp.Diff(want, texts(l.query(logQuery{}))); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLogBuffer_keepsRecentIncarnations(t *testing.T) {
+	l := new(logBuffer)
+	for i := 1; i <= keepIncarnations+2; i++ {
+		l.writer(streamStdout, i).Write([]byte("x\n"))
+	}
+	lines := l.query(logQuery{})
+	if len(lines) != keepIncarnations {
+		t.Fatalf("got %d lines, expected %d", len(lines), keepIncarnations)
+	}
+	if lines[0].incarnation != 3 {
+		t.Fatalf("oldest line is from incarnation %d, expected 3", lines[0].incarnation)
+	}
+	if got := l.String(); got != "x\n" {
+		t.Fatalf("output of the latest incarnation=%q", got)
+	}
+}
+
+func TestLogBuffer_query(t *testing.T) {
+	l := new(logBuffer)
+	l.writer(streamStdout, 1).Write([]byte("a\nb\n"))
+	l.writer(streamStderr, 1).Write([]byte("c\n"))
+	mid := time.Now()
+	time.Sleep(time.Millisecond * 5)
+	l.writer(streamStdout, 2).Write([]byte("d\n"))
+	l.writer(streamStderr, 2).Write([]byte("e\n"))
+
+	tests := []struct {
+		name string
+		q    logQuery
+		want []string
+	}{
+		{"all", logQuery{}, []string{"stdout #1 a", "stdout #1 b", "stderr #1 c", "stdout #2 d", "stderr #2 e"}},
+		{"stream", logQuery{stream: streamStderr}, []string{"stderr #1 c", "stderr #2 e"}},
+		{"incarnation", logQuery{incarnation: 1}, []string{"stdout #1 a", "stdout #1 b", "stderr #1 c"}},
+		{"since", logQuery{since: mid}, []string{"stdout #2 d", "stderr #2 e"}},
+		{"tail", logQuery{tail: 2}, []string{"stdout #2 d", "stderr #2 e"}},
+		{"combined", logQuery{stream: streamStdout, incarnation: 1, tail: 1}, []string{"stdout #1 b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, texts(l.query(tt.q))); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestLogBuffer_since(t *testing.T) {
+	l := new(logBuffer)
+	l.Write([]byte("a\n"))
+	b, off := l.since(0)
+	if string(b) != "a\n" {
+		t.Fatalf("got=%q", b)
+	}
+	l.Reset()
+	l.Write([]byte("b\n"))
+	if b, _ = l.since(off); string(b) != "b\n" {
+		t.Fatalf("got=%q after reset", b)
+	}
+}
+
+func TestParseLogQuery(t *testing.T) {
+	now := time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    logQuery
+		wantErr bool
+	}{
+		{in: "", want: logQuery{}},
+		{in: "since=5m", want: logQuery{since: now.Add(-5 * time.Minute)}},
+		{in: "since=2019-07-01T09:00:00Z", want: logQuery{since: now.Add(-time.Hour)}},
+		{in: "stream=stderr&incarnation=3&tail=10", want: logQuery{stream: streamStderr, incarnation: 3, tail: 10}},
+		{in: "since=yesterday", wantErr: true},
+		{in: "stream=stdin", wantErr: true},
+		{in: "tail=-1", wantErr: true},
+		{in: "incarnation=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		v, err := url.ParseQuery(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseLogQuery(v, now)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseLogQuery(%q) err=%v, wantErr=%v", tt.in, err, tt.wantErr)
+		}
+		if err == nil && (!got.since.Equal(tt.want.since) || got.stream != tt.want.stream ||
+			got.incarnation != tt.want.incarnation || got.tail != tt.want.tail) {
+			t.Fatalf("parseLogQuery(%q)=%+v, want=%+v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -18,24 +18,41 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
-	"github.com/ahmetb/rundev/lib/ignore"
+	"github.com/ahmetb/rundev/lib/signals"
 	"github.com/ahmetb/rundev/lib/types"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 var (
 	flRunCmd               string
 	flBuildCmds            string
+	flProcs                string
+	flReloadRules          string
 	flAddr                 string
 	flSyncDir              string
+	flMounts               string
+	flWorkDir              string
 	flClientSecret         string
 	flIgnorePatterns       string
 	flChildPort            int
+	flEagerBuild           bool
+	flWorker               bool
+	flStopSignal           string
+	flStopGracePeriod      time.Duration
+	flRestartPolicy        string
+	flRestartBackoff       time.Duration
+	flBlueGreen            bool
+	flAltChildPort         int
 	flProcessListenTimeout time.Duration
+	flReadyPath            string
+	flReadyInterval        time.Duration
+	flReadyTimeout         time.Duration
 )
 
 func init() {
@@ -44,43 +61,81 @@ func init() {
 	if p := os.Getenv("PORT"); p != "" {
 		listenAddr = ":" + p
 	}
-	flag.StringVar(&flSyncDir, "sync-dir", ".", "directory to sync")
+	flag.StringVar(&flSyncDir, "sync-dir", ".", "directory to sync (if -mounts is not specified)")
+	flag.StringVar(&flMounts, "mounts", "", "(JSON encoded []Mount) directories to sync with their exclusion rules, overrides -sync-dir and -ignore-patterns")
+	flag.StringVar(&flWorkDir, "work-dir", "", "(optional) working directory for build and run commands, defaults to the first sync directory")
 	flag.StringVar(&flClientSecret, "client-secret", "", "(optional) secret to authenticate patches from rundev client")
 	flag.StringVar(&flAddr, "addr", listenAddr, "network address to start the daemon")
 	flag.StringVar(&flBuildCmds, "build-cmds", "", "(JSON encoded [][]string) commands to rebuild the user app (inside the container)")
 	flag.StringVar(&flRunCmd, "run-cmd", "", "(JSON array encoded as string) command to start the user app (inside the container)")
-	flag.StringVar(&flIgnorePatterns, "ignore-patterns", "", "(JSON array encoded as string) exclusion rules in .dockerignore")
+	flag.StringVar(&flProcs, "procs", "", "(JSON encoded []Process) named processes to run along with the user app, such as workers or asset watchers")
+	flag.StringVar(&flReloadRules, "reload-rules", "", "(JSON encoded []ReloadRule) how the user app picks up changed files: none, signal, restart or rebuild (default)")
+	flag.StringVar(&flIgnorePatterns, "ignore-patterns", "", "(JSON array encoded as string) exclusion rules in .dockerignore (if -mounts is not specified)")
 	flag.IntVar(&flChildPort, "user-port", 5555, "PORT environment variable passed to the user app")
+	flag.BoolVar(&flEagerBuild, "eager-build", false, "rebuild and restart the user app as soon as a patch is applied, instead of on the next request")
+	flag.BoolVar(&flWorker, "worker", false, "run a user app that doesn't serve HTTP: start it on boot and after every patch, without waiting for it to listen on PORT")
+	flag.StringVar(&flStopSignal, "stop-signal", "SIGTERM", "signal sent to the user app to stop it")
+	flag.DurationVar(&flStopGracePeriod, "stop-grace-period", time.Second*3, "time to wait for the user app to exit after the stop signal before killing it")
+	flag.StringVar(&flRestartPolicy, "restart-policy", string(restartNever), "restart the user app when it exits on its own: never (restart on next request), on-failure or always")
+	flag.DurationVar(&flRestartBackoff, "restart-backoff", time.Second, "delay before restarting the user app, doubled after each crash")
+	flag.BoolVar(&flBlueGreen, "blue-green", false, "build and start new versions of the user app on -alt-user-port while the previous version keeps serving, and switch over once it listens")
+	flag.IntVar(&flAltChildPort, "alt-user-port", 0, "PORT alternated with -user-port for new versions of the user app with -blue-green (default: -user-port + 1)")
 	flag.DurationVar(&flProcessListenTimeout, "process-listen-timeout", time.Second*4, "time to wait for user app to listen on PORT")
-	flag.Parse()
+	flag.StringVar(&flReadyPath, "ready-path", "", "(optional) HTTP path polled until it responds with a 2xx or 3xx status to consider the user app ready, instead of waiting for PORT to accept connections")
+	flag.DurationVar(&flReadyInterval, "ready-interval", time.Millisecond*200, "time between the requests to -ready-path")
+	flag.DurationVar(&flReadyTimeout, "ready-timeout", time.Second*30, "time to wait for -ready-path to succeed (replaces -process-listen-timeout)")
 }
 
 func main() {
+	if os.Getpid() == 1 {
+		// running as the init process of the container, start the daemon
+		// as a child process
+		os.Exit(runInit())
+	}
+	flag.Parse()
 	// TODO(ahmetb) instead of crashing the process on flag errors, consider serving error response type so it encourages a redeploy
 	log.Printf("rundevd running as pid %d", os.Getpid())
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		sig := <-signalCh
 		log.Printf("[debug] termination signal received: %s", sig)
 		cancel()
 	}()
 
-	if flSyncDir == "" {
+	if flSyncDir == "" && flMounts == "" {
 		log.Fatal("-sync-dir is empty")
 	}
-	// TODO(ahmetb) check if flSyncDir is a directory
+	// TODO(ahmetb) check if sync dirs are directories
 	if flAddr == "" {
 		log.Fatal("-addr is empty")
 	}
 	if flProcessListenTimeout <= 0 {
 		log.Fatal("-process-listen-timeout must be positive")
 	}
+	if flReadyPath != "" {
+		if !strings.HasPrefix(flReadyPath, "/") {
+			log.Fatalf("-ready-path (%q) must start with a slash", flReadyPath)
+		}
+		if flReadyInterval <= 0 || flReadyTimeout <= 0 {
+			log.Fatal("-ready-interval and -ready-timeout must be positive")
+		}
+		flProcessListenTimeout = flReadyTimeout
+	}
 	if flChildPort <= 0 || flChildPort > 65535 {
 		log.Fatalf("-user-port value (%d) is invalid", flChildPort)
 	}
+	if flWorker && flBlueGreen {
+		log.Fatal("-worker can't be used with -blue-green")
+	}
+	if flAltChildPort == 0 {
+		flAltChildPort = flChildPort + 1
+	}
+	if flAltChildPort < 0 || flAltChildPort > 65535 || flAltChildPort == flChildPort {
+		log.Fatalf("-alt-user-port value (%d) is invalid", flAltChildPort)
+	}
 	if flRunCmd == "" {
 		log.Fatal("-run-cmd is empty")
 	}
@@ -98,30 +153,103 @@ func main() {
 			log.Fatalf("failed to parse -build-cmds: %s", err)
 		}
 	}
+	buildConditions, err := parseBuildConditions(buildCmds)
+	if err != nil {
+		log.Fatalf("invalid -build-cmds: %+v", err)
+	}
 
-	var ignorePatterns []string
-	if flIgnorePatterns != "" {
-		if err := json.Unmarshal([]byte(flIgnorePatterns), &ignorePatterns); err != nil {
-			log.Fatalf("failed to parse -ignore-patterns: %v", err)
+	var reloadRules types.ReloadRules
+	if flReloadRules != "" {
+		if err := json.Unmarshal([]byte(flReloadRules), &reloadRules); err != nil {
+			log.Fatalf("failed to parse -reload-rules: %v", err)
 		}
 	}
+	parsedReloadRules, err := parseReloadRules(reloadRules)
+	if err != nil {
+		log.Fatalf("invalid -reload-rules: %+v", err)
+	}
 
-	handler := newDaemonServer(daemonOpts{
+	var mounts types.Mounts
+	if flMounts != "" {
+		if err := json.Unmarshal([]byte(flMounts), &mounts); err != nil {
+			log.Fatalf("failed to parse -mounts: %v", err)
+		} else if len(mounts) == 0 {
+			log.Fatal("-mounts was empty (parsed into zero elements)")
+		}
+		for i, m := range mounts {
+			if m.Remote == "" {
+				log.Fatalf("-mounts: remote directory of mount #%d is empty", i)
+			}
+		}
+	} else {
+		var ignorePatterns []string
+		if flIgnorePatterns != "" {
+			if err := json.Unmarshal([]byte(flIgnorePatterns), &ignorePatterns); err != nil {
+				log.Fatalf("failed to parse -ignore-patterns: %v", err)
+			}
+		}
+		mounts = types.Mounts{{Local: ".", Remote: flSyncDir, Ignores: ignorePatterns}}
+	}
+	if flWorkDir == "" {
+		flWorkDir = mounts[0].Remote
+	}
+
+	stopSignal, err := signals.Parse(flStopSignal)
+	if err != nil {
+		log.Fatalf("invalid -stop-signal: %v", err)
+	}
+
+	restartPolicy, err := parseRestartPolicy(flRestartPolicy)
+	if err != nil {
+		log.Fatalf("invalid -restart-policy: %v", err)
+	}
+
+	var procs types.Processes
+	if flProcs != "" {
+		if err := json.Unmarshal([]byte(flProcs), &procs); err != nil {
+			log.Fatalf("failed to parse -procs: %v", err)
+		}
+	}
+	procSpecs, err := parseProcs(procs, restartPolicy)
+	if err != nil {
+		log.Fatalf("invalid -procs: %+v", err)
+	}
+
+	srv := newDaemonServer(daemonOpts{
 		clientSecret:    flClientSecret,
-		syncDir:         flSyncDir,
+		roots:           newSyncRoots(mounts),
+		workDir:         flWorkDir,
 		runCmd:          runCmd,
+		procs:           procSpecs,
 		buildCmds:       buildCmds,
+		buildConditions: buildConditions,
+		reloadRules:     parsedReloadRules,
 		childPort:       flChildPort,
 		portWaitTimeout: flProcessListenTimeout,
-		ignores:         ignore.NewFileIgnores(ignorePatterns),
+		readyPath:       flReadyPath,
+		readyInterval:   flReadyInterval,
+		eagerBuild:      flEagerBuild,
+		worker:          flWorker,
+		stopSignal:      stopSignal,
+		stopGracePeriod: flStopGracePeriod,
+		restartPolicy:   restartPolicy,
+		restartBackoff:  flRestartBackoff,
+		blueGreen:       flBlueGreen,
+		altPort:         flAltChildPort,
 	})
 
+	srv.bootProcs()
+	if flWorker {
+		go srv.startEagerly()
+	}
+
 	localServer := http.Server{
-		Handler: handler,
+		Handler: srv,
 		Addr:    flAddr}
 	go func() {
 		<-ctx.Done()
 		log.Println("shutting down daemon server")
+		srv.stopProcess()
 		localServer.Shutdown(ctx)
 	}()
 	log.Printf("daemon server starting at %s", flAddr)
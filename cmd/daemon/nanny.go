@@ -15,7 +15,8 @@
 package main
 
 import (
-	"bytes"
+	"fmt"
+	"github.com/ahmetb/rundev/lib/types"
 	"github.com/pkg/errors"
 	"io"
 	"log"
@@ -24,12 +25,32 @@ import (
 	"strconv"
 	"sync"
 	"syscall"
+	"time"
+)
+
+const (
+	groupExitPollInterval = time.Millisecond * 10
+	groupKillTimeout      = time.Millisecond * 500 // before falling back to killing the process tree
+	killWaitTimeout       = time.Second * 2
 )
 
 type nanny interface {
 	Running() bool
-	Restart() error // starts if not running
-	Kill()
+	Restart() error      // starts if not running
+	Kill()               // also clears the crash loop state
+	LastExit() *procExit // nil if no process has exited yet
+	Exits() []procExit   // recent exits of processes not stopped by the nanny, oldest first
+	CrashLooping() bool
+	Port() int // port the current process listens on
+	Pid() int  // of the current process, zero if not running
+	Signal(syscall.Signal) error
+
+	// StartStandby starts a new process on port while the current one keeps
+	// running, and Promote stops the current process and makes the standby
+	// process current.
+	StartStandby(port int) error
+	Promote() error
+	DiscardStandby()
 }
 
 type procNanny struct {
@@ -37,22 +58,81 @@ type procNanny struct {
 	args []string
 	opts procOpts
 
-	mu     sync.RWMutex
-	proc   *os.Process
-	active bool
+	startMu      sync.Mutex // serializes stopping and starting processes, so a restart isn't interleaved with another
+	mu           sync.RWMutex
+	proc         *procHandle
+	standby      *procHandle // started, not yet promoted
+	port         int         // of the current process
+	active       bool
+	lastExit     *procExit
+	incarnation  int
+	exits        []procExit
+	crashes      int  // consecutive exits shortly after starting
+	crashLooping bool // stopped restarting after too many crashes
+	restartTimer *time.Timer
 }
 
 type procOpts struct {
-	port int
-	dir  string
-	logs *bytes.Buffer
+	name        string // of the process in events
+	port        int
+	dir         string
+	logs        *logBuffer
+	events      *eventLog      // nil if not recording events
+	stopSignal  syscall.Signal // sent to stop the process, SIGTERM if not set
+	gracePeriod time.Duration  // time to wait after stopSignal before sending SIGKILL, zero kills right away
+
+	restartPolicy  restartPolicy // restarting processes exiting on their own, never if not set
+	restartBackoff time.Duration // delay before the first restart, doubled after each crash
+}
+
+// procHandle is a started process.
+type procHandle struct {
+	proc        *os.Process
+	incarnation int
+	port        int
+	started     time.Time
+	exited      chan struct{}    // closed when the process exits
+	state       *os.ProcessState // set before exited is closed
+	logTail     string           // set before exited is closed
+
+	stopping bool // stopped by the nanny, guarded by procNanny.mu
+}
+
+// procExit describes how a process has exited.
+type procExit struct {
+	pid         int
+	incarnation int
+	status      string // e.g. "exit status 1", "signal: terminated"
+	code        int    // -1 if terminated by a signal
+	signal      string
+	at          time.Time
+	uptime      time.Duration
+	logTail     string        // last lines of output
+	stopped     bool          // stopped by the nanny, rather than exiting on its own
+	stopTook    time.Duration // time from the stop signal to exit
+	killed      bool          // grace period ran out and the process group was killed
+}
+
+func (e procExit) String() string {
+	s := fmt.Sprintf("#%d pid=%d status=%q at=%s uptime=%v", e.incarnation, e.pid, e.status, e.at.Format(time.RFC3339), e.uptime.Round(time.Millisecond))
+	if e.stopped {
+		s += fmt.Sprintf(" stopped in %v", e.stopTook)
+		if e.killed {
+			s += " (killed after grace period)"
+		}
+	}
+	return s
 }
 
 func newProcessNanny(cmd string, args []string, opts procOpts) nanny {
+	if opts.stopSignal == 0 {
+		opts.stopSignal = syscall.SIGTERM
+	}
 	return &procNanny{
 		cmd:  cmd,
 		args: args,
 		opts: opts,
+		port: opts.port,
 	}
 }
 
@@ -63,70 +143,356 @@ func (p *procNanny) Running() bool {
 }
 
 func (p *procNanny) Kill() {
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
+	p.mu.Lock()
+	p.crashes, p.crashLooping = 0, false
+	p.mu.Unlock()
 	p.kill()
 }
 
 func (p *procNanny) Restart() error {
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
 	return p.replace()
 }
 
-// kill sends a SIGKILL to the process if it's running.
-func (p *procNanny) kill() {
+func (p *procNanny) LastExit() *procExit {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.lastExit
+}
+
+func (p *procNanny) Exits() []procExit {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]procExit(nil), p.exits...)
+}
+
+func (p *procNanny) CrashLooping() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.crashLooping
+}
+
+func (p *procNanny) Port() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.port
+}
+
+func (p *procNanny) Pid() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.active || p.proc == nil {
+		return 0
+	}
+	return p.proc.proc.Pid
+}
+
+// Signal sends sig to the current process, but not to the rest of its group,
+// as in reloading the configuration with SIGHUP.
+func (p *procNanny) Signal(sig syscall.Signal) error {
+	pid := p.Pid()
+	if pid == 0 {
+		return errors.New("process is not running")
+	}
+	return errors.Wrapf(syscall.Kill(pid, sig), "failed to send %v to pid %d", sig, pid)
+}
+
+func (p *procNanny) StartStandby(port int) error {
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
+	p.discardStandby()
+	return p.spawn(port, true)
+}
+
+func (p *procNanny) Promote() error {
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if p.proc != nil {
-		pid := -p.proc.Pid // negative value: ID of process group
-		log.Printf("killing pid %d", pid)
-		// TODO using negative PID (pgrp kill) not working on gVisor
-		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
-			log.Printf("warning: failed to kill: %v", err)
-		} else {
-			log.Printf("killed pid %d", pid)
-		}
+	h := p.standby
+	if h == nil {
+		p.mu.Unlock()
+		return errors.New("no standby process to promote")
+	}
+	if p.restartTimer != nil {
+		p.restartTimer.Stop()
+		p.restartTimer = nil
+	}
+	old := p.detach(p.proc)
+	p.proc, p.standby, p.port = h, nil, h.port
+	select {
+	case <-h.exited:
+		p.active = false
+	default:
+		p.active = true
+	}
+	log.Printf("promoted process #%d on port %d", h.incarnation, h.port)
+	p.lifecycle(h.incarnation, "promoted on port %d", h.port)
+	p.mu.Unlock()
+
+	p.stopHandle(old) // requests are already served by the promoted process
+	return nil
+}
 
-		p.proc.Release()
-		p.proc = nil
+func (p *procNanny) DiscardStandby() {
+	p.startMu.Lock()
+	defer p.startMu.Unlock()
+	p.discardStandby()
+}
+
+// discardStandby stops the standby process, if any. It must be called while
+// holding startMu.
+func (p *procNanny) discardStandby() {
+	p.mu.Lock()
+	h := p.detach(p.standby)
+	p.standby = nil
+	p.mu.Unlock()
+	p.stopHandle(h)
+}
+
+// kill stops the process if it's running, first with the stop signal and
+// after the grace period with a SIGKILL.
+func (p *procNanny) kill() {
+	p.mu.Lock()
+	if p.restartTimer != nil {
+		p.restartTimer.Stop()
+		p.restartTimer = nil
 	}
+	proc, standby := p.detach(p.proc), p.detach(p.standby)
+	p.proc, p.standby = nil, nil
 	p.active = false
+	p.mu.Unlock()
+
+	p.stopHandle(proc)
+	p.stopHandle(standby)
+}
+
+// lifecycle adds a lifecycle event of the process incarnation to the logs.
+func (p *procNanny) lifecycle(incarnation int, format string, args ...interface{}) {
 	if p.opts.logs != nil {
-		p.opts.logs.Reset()
+		p.opts.logs.event(incarnation, fmt.Sprintf(format, args...))
+	}
+}
+
+// exitEvent records the exit of a process in the events.
+func (p *procNanny) exitEvent(e *procExit) {
+	p.opts.events.emit(types.Event{
+		Type:        types.EventProcessExited,
+		Process:     p.opts.name,
+		Incarnation: e.incarnation,
+		Pid:         e.pid,
+		ExitCode:    e.code,
+		Status:      e.status,
+		Signal:      e.signal,
+		Duration:    e.uptime,
+		Stopped:     e.stopped,
+	})
+}
+
+// detach marks h as being stopped by the nanny, so its exit isn't recorded
+// as a crash, and returns it if it's still running (nil otherwise). It must
+// be called while holding the lock.
+func (p *procNanny) detach(h *procHandle) *procHandle {
+	if h == nil {
+		return nil
+	}
+	select {
+	case <-h.exited:
+		// exited on its own, recorded by the goroutine waiting on it
+		h.proc.Release()
+		return nil
+	default:
+		h.stopping = true
+		return h
+	}
+}
+
+// stopHandle stops the process h returned by detach, if any. It must be
+// called without holding the lock, as it waits up to the grace period.
+func (p *procNanny) stopHandle(h *procHandle) {
+	if h == nil {
+		return
+	}
+	e := p.stop(h)
+	h.proc.Release()
+	log.Printf("process stopped: %s", e)
+	p.lifecycle(h.incarnation, "stopped: %s", e)
+	p.exitEvent(e)
+
+	p.mu.Lock()
+	p.lastExit = e
+	p.mu.Unlock()
+}
+
+// stop signals the process group of h and waits for the process and the
+// group members to exit.
+func (p *procNanny) stop(h *procHandle) *procExit {
+	pid := h.proc.Pid
+	start := time.Now()
+	out := &procExit{pid: pid, incarnation: h.incarnation, stopped: true}
+	procs := watchProcs(pid)
+
+	if p.opts.gracePeriod > 0 {
+		log.Printf("sending %v to pid %d", p.opts.stopSignal, pid)
+		procs.signal(p.opts.stopSignal)
+		if procs.wait(p.opts.gracePeriod) {
+			out.stopTook = time.Since(start)
+			out.setExitStatus(h)
+			return out
+		}
+		log.Printf("pid %d did not exit in %v", pid, p.opts.gracePeriod)
+		out.killed = true
+	}
+
+	log.Printf("killing pid %d", pid)
+	if procs.kill(killWaitTimeout) {
+		log.Printf("killed pid %d", pid)
+	} else {
+		log.Printf("warning: pid %d still running after SIGKILL", pid)
+	}
+	out.stopTook = time.Since(start)
+	out.setExitStatus(h)
+	return out
+}
+
+// setExitStatus records the exit status of the stopped process, after giving
+// it a moment to be reaped.
+func (e *procExit) setExitStatus(h *procHandle) {
+	select {
+	case <-h.exited:
+	case <-time.After(groupKillTimeout):
+	}
+	e.status, e.at = exitStatus(h), time.Now()
+	e.uptime = e.at.Sub(h.started)
+	e.code = -1 // unless reaped
+	select {
+	case <-h.exited:
+		if h.state != nil {
+			e.code, e.signal = procExitCode(h.state)
+		}
+	default:
+	}
+}
+
+// procExitCode returns the exit code of the process, and the signal that
+// terminated it (in which case the exit code is -1).
+func procExitCode(s *os.ProcessState) (int, string) {
+	if ws, ok := s.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return s.ExitCode(), ws.Signal().String()
+	}
+	return s.ExitCode(), ""
+}
+
+func exitStatus(h *procHandle) string {
+	select {
+	case <-h.exited:
+		if h.state == nil {
+			return "unknown"
+		}
+		return h.state.String()
+	default:
+		return "running"
 	}
 }
 
+// replace stops the current process and starts a new one. It must be called
+// while holding startMu.
 func (p *procNanny) replace() error {
 	p.kill()
+	p.mu.RLock()
+	port := p.port
+	p.mu.RUnlock()
+	return p.spawn(port, false)
+}
 
+// spawn starts a new incarnation of the process listening on port, either as
+// the current process or as the standby process.
+func (p *procNanny) spawn(port int, standby bool) error {
 	newProc := exec.Command(p.cmd, p.args...)
 	newProc.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true} // create a new GID
 	if p.opts.dir != "" {
 		newProc.Dir = p.opts.dir
 	}
-	newProc.Stdout = io.MultiWriter(p.opts.logs, os.Stdout)
-	newProc.Stderr = io.MultiWriter(p.opts.logs, os.Stderr)
+	p.mu.Lock()
+	p.incarnation++
+	incarnation := p.incarnation
+	p.mu.Unlock()
 
-	if p.opts.port > 0 {
-		newProc.Env = append(os.Environ(), "PORT="+strconv.Itoa(p.opts.port))
+	newProc.Stdout, newProc.Stderr = os.Stdout, os.Stderr
+	var stdout, stderr *logWriter
+	if p.opts.logs != nil {
+		stdout, stderr = p.opts.logs.writer(streamStdout, incarnation), p.opts.logs.writer(streamStderr, incarnation)
+		newProc.Stdout = io.MultiWriter(stdout, os.Stdout)
+		newProc.Stderr = io.MultiWriter(stderr, os.Stderr)
+	}
+
+	if port > 0 {
+		newProc.Env = append(os.Environ(), "PORT="+strconv.Itoa(port))
 	}
 	log.Printf("proc start")
 	if err := newProc.Start(); err != nil {
+		p.lifecycle(incarnation, "failed to start: %v", err)
 		return errors.Wrap(err, "error starting process")
 	}
+	p.lifecycle(incarnation, "started pid=%d port=%d", newProc.Process.Pid, port)
+	p.opts.events.emit(types.Event{
+		Type:        types.EventProcessStarted,
+		Process:     p.opts.name,
+		Incarnation: incarnation,
+		Pid:         newProc.Process.Pid,
+		Port:        port,
+	})
+	h := &procHandle{proc: newProc.Process, incarnation: incarnation, port: port, started: time.Now(), exited: make(chan struct{})}
 
 	p.mu.Lock()
-	p.proc = newProc.Process
-	p.active = true
+	if standby {
+		p.standby = h
+	} else {
+		p.proc, p.port = h, port
+		p.active = true
+	}
 	p.mu.Unlock()
 
-	go func(origProc *os.Process) {
+	go func() {
 		_ = newProc.Wait()
+		h.state = newProc.ProcessState
+		if p.opts.logs != nil {
+			stdout.Flush()
+			stderr.Flush()
+			h.logTail = tailLines(string(p.opts.logs.output(h.incarnation)), exitLogLines)
+		}
+		close(h.exited) // before locking, stop() may be waiting on it
 		p.mu.Lock()
-		if p.proc == origProc {
+		if p.proc == h {
 			p.active = false
 		}
+		if !h.stopping {
+			e := &procExit{
+				pid:         h.proc.Pid,
+				incarnation: h.incarnation,
+				status:      exitStatus(h),
+				at:          time.Now(),
+				uptime:      time.Since(h.started),
+				logTail:     h.logTail,
+			}
+			e.code, e.signal = procExitCode(h.state)
+			p.lastExit = e
+			if p.standby == h {
+				log.Printf("standby process exited: %s", e)
+				p.lifecycle(h.incarnation, "standby exited: %s", e)
+				p.exitEvent(e)
+			} else {
+				log.Printf("process exited: %s", e)
+				p.lifecycle(h.incarnation, "exited: %s", e)
+				p.exitEvent(e)
+				p.recordExit(h, e)
+			}
+		}
 		p.mu.Unlock()
-	}(newProc.Process)
+	}()
 
 	return nil
 }
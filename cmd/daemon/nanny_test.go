@@ -16,6 +16,8 @@ package main
 
 import (
 	"context"
+	"github.com/ahmetb/rundev/lib/types"
+	"strings"
 	"testing"
 	"time"
 )
@@ -88,3 +90,168 @@ func TestExecCapturesExit(t *testing.T) {
 		time.Sleep(time.Millisecond * 5)
 	}
 }
+
+func TestStopGracefully(t *testing.T) {
+	n := newProcessNanny("/bin/sh", []string{"-c", `trap "exit 3" TERM; while true; do sleep 0.01; done`},
+		procOpts{gracePeriod: time.Second * 2})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100) // let the shell set up the trap
+	n.Kill()
+	e := n.LastExit()
+	if e == nil {
+		t.Fatal("exit not recorded")
+	}
+	if e.killed {
+		t.Fatalf("process was killed instead of exiting on SIGTERM: %s", e)
+	}
+	if e.status != "exit status 3" || e.code != 3 {
+		t.Fatalf("unexpected exit status: %s (code %d)", e, e.code)
+	}
+	if x := e.export(); !x.Stopped || x.Killed || x.StopDuration <= 0 || x.ExitCode != 3 {
+		t.Fatalf("unexpected exported exit: %+v", x)
+	}
+}
+
+func TestStopKillsAfterGracePeriod(t *testing.T) {
+	n := newProcessNanny("/bin/sh", []string{"-c", `trap "" TERM; while true; do sleep 0.01; done`},
+		procOpts{gracePeriod: time.Millisecond * 200})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	start := time.Now()
+	n.Kill()
+	e := n.LastExit()
+	if e == nil || !e.killed {
+		t.Fatalf("process should have been killed: %v", e)
+	}
+	if e.code != -1 || e.signal != "killed" {
+		t.Fatalf("unexpected exit code %d, signal %q", e.code, e.signal)
+	}
+	if x := e.export(); !x.Killed || x.StopDuration < time.Millisecond*200 {
+		t.Fatalf("unexpected exported exit: %+v", x)
+	}
+	if d := time.Since(start); d < time.Millisecond*200 || d > time.Second*2 {
+		t.Fatalf("stop took %v", d)
+	}
+	if n.Running() {
+		t.Fatal("killed process still running")
+	}
+}
+
+func TestStopDoesNotBlockReaders(t *testing.T) {
+	n := newProcessNanny("/bin/sh", []string{"-c", `trap "" TERM; while true; do sleep 0.01; done`},
+		procOpts{gracePeriod: time.Second})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	done := make(chan struct{})
+	go func() {
+		n.Kill()
+		close(done)
+	}()
+	time.Sleep(time.Millisecond * 100) // in the grace period
+	start := time.Now()
+	if n.Running() || n.Pid() != 0 {
+		t.Fatal("process being stopped reported as running")
+	}
+	_, _ = n.Port(), n.LastExit()
+	if d := time.Since(start); d > time.Millisecond*100 {
+		t.Fatalf("reading the state took %v while stopping", d)
+	}
+	<-done
+}
+
+func TestExitRecorded(t *testing.T) {
+	n := newProcessNanny("/bin/sh", []string{"-c", "exit 2"}, procOpts{})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; n.Running() && i < 100; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	time.Sleep(time.Millisecond * 10)
+	e := n.LastExit()
+	if e == nil || e.stopped || e.status != "exit status 2" {
+		t.Fatalf("unexpected exit: %v", e)
+	}
+}
+
+func TestStandbyPromote(t *testing.T) {
+	n := newProcessNanny("sleep", []string{"100"}, procOpts{port: 5555})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.StartStandby(5556); err != nil {
+		t.Fatal(err)
+	}
+	if n.Port() != 5555 {
+		t.Fatalf("port changed before promotion: %d", n.Port())
+	}
+	if err := n.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Running() {
+		t.Fatal("promoted process not running")
+	}
+	if n.Port() != 5556 {
+		t.Fatalf("expected port 5556 after promotion, got %d", n.Port())
+	}
+	if e := n.LastExit(); e == nil || e.incarnation != 1 || !e.stopped {
+		t.Fatalf("previous process not stopped: %v", e)
+	}
+	if err := n.Promote(); err == nil {
+		t.Fatal("expected error promoting without a standby process")
+	}
+}
+
+func TestLogsKeptAcrossRestarts(t *testing.T) {
+	logs := new(logBuffer)
+	n := newProcessNanny("/bin/sh", []string{"-c", "echo out; echo err >&2; sleep 100"}, procOpts{logs: logs})
+	defer n.Kill()
+	for i := 0; i < 2; i++ {
+		if err := n.Restart(); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, time.Second*5, func() bool { return strings.Count(string(logs.output(i+1)), "\n") == 2 })
+	}
+	n.Kill()
+
+	if got := logs.query(logQuery{stream: streamStderr, incarnation: 1}); len(got) != 1 || got[0].text != "err" {
+		t.Fatalf("stderr of the first incarnation: %+v", got)
+	}
+	if got := logs.String(); got != "out\nerr\n" && got != "err\nout\n" {
+		t.Fatalf("output of the latest incarnation=%q", got)
+	}
+	events := logs.query(logQuery{stream: streamLifecycle, incarnation: 1})
+	if len(events) != 2 || !strings.HasPrefix(events[0].text, "started") || !strings.HasPrefix(events[1].text, "stopped") {
+		t.Fatalf("unexpected lifecycle events: %+v", events)
+	}
+}
+
+func TestProcessEvents(t *testing.T) {
+	events := new(eventLog)
+	n := newProcessNanny("/bin/sh", []string{"-c", "exit 4"}, procOpts{name: "worker", events: events})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool { return !n.Running() })
+	waitFor(t, time.Second*5, func() bool { got, _ := events.since(0); return len(got) == 2 })
+
+	got, _ := events.since(0)
+	if got[0].Type != types.EventProcessStarted || got[0].Process != "worker" || got[0].Pid == 0 {
+		t.Fatalf("unexpected start event: %+v", got[0])
+	}
+	if got[1].Type != types.EventProcessExited || got[1].ExitCode != 4 || got[1].Stopped {
+		t.Fatalf("unexpected exit event: %+v", got[1])
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tcpListen = "0A" // socket state in /proc/net/tcp
+
+	// listenPortPollInterval is how often the ports of a user process that
+	// is not listening on $PORT yet are checked.
+	listenPortPollInterval = time.Millisecond * 250
+)
+
+// parseProcNetTCP parses the contents of /proc/net/tcp or /proc/net/tcp6, and
+// returns the ports of the listening sockets by their inode.
+func parseProcNetTCP(b []byte) map[uint64]int {
+	out := make(map[uint64]int)
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		f := strings.Fields(string(line))
+		if i == 0 || len(f) < 10 || f[3] != tcpListen {
+			continue // header or not listening
+		}
+		j := strings.LastIndexByte(f[1], ':')
+		if j < 0 {
+			continue
+		}
+		port, err1 := strconv.ParseUint(f[1][j+1:], 16, 16)
+		inode, err2 := strconv.ParseUint(f[9], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		out[inode] = int(port)
+	}
+	return out
+}
+
+// socketInodes returns the inodes of the sockets the processes have open.
+// Processes that exit while listing are skipped.
+func socketInodes(pids []int) map[uint64]bool {
+	out := make(map[uint64]bool)
+	for _, pid := range pids {
+		fds, err := filepath.Glob(filepath.Join("/proc", strconv.Itoa(pid), "fd", "*"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			v, err := os.Readlink(fd)
+			if err != nil || !strings.HasPrefix(v, "socket:[") {
+				continue
+			}
+			if inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(v, "socket:["), "]"), 10, 64); err == nil {
+				out[inode] = true
+			}
+		}
+	}
+	return out
+}
+
+// listeningPorts returns the TCP ports that the process group and descendants
+// of pid listen on, in ascending order.
+func listeningPorts(pid int) ([]int, error) {
+	procs, err := readProcs()
+	if err != nil {
+		return nil, err
+	}
+	pids := processTree(pid, procs)
+	for _, p := range procs {
+		if p.pgrp == pid && p.pid != pid {
+			pids = append(pids, p.pid)
+		}
+	}
+	inodes := socketInodes(pids)
+
+	seen := make(map[int]bool)
+	var out []int
+	for _, f := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		b, err := ioutil.ReadFile(f)
+		if os.IsNotExist(err) {
+			continue // no IPv6
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", f)
+		}
+		for inode, port := range parseProcNetTCP(b) {
+			if inodes[inode] && !seen[port] {
+				seen[port] = true
+				out = append(out, port)
+			}
+		}
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// otherListenPort returns the port to proxy to for a process expected to
+// listen on port, if it listens on a single other port instead.
+func otherListenPort(ports []int, port int) (int, bool) {
+	if len(ports) != 1 || ports[0] == port {
+		return 0, false
+	}
+	return ports[0], true
+}
+
+// listenPortsHint explains the ports a process that is not ready listens on.
+func listenPortsHint(ports []int, port int) string {
+	var s []string
+	for _, p := range ports {
+		if p == port {
+			return "" // listening, but not ready
+		}
+		s = append(s, strconv.Itoa(p))
+	}
+	if len(s) == 0 {
+		return " (it's not listening on any TCP port)"
+	}
+	return " (it's listening on ports " + strings.Join(s, ", ") + " instead, make it listen on $PORT)"
+}
+
+// appPort returns the port requests are proxied to.
+func (srv *daemonServer) appPort() int {
+	srv.startLock.Lock()
+	p := srv.listenPort
+	srv.startLock.Unlock()
+	if p != 0 {
+		return p
+	}
+	return srv.procNanny.Port()
+}
+
+func (srv *daemonServer) setListenPort(p int) {
+	srv.startLock.Lock()
+	srv.listenPort = p
+	srv.startLock.Unlock()
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestParseProcNetTCP(t *testing.T) {
+	in := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0BB8 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 911 1 0000000060241bc1 100 0 0 10 0
+   1: 00000000:15B3 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 662 1 00000000ef83b787 100 0 0 10 0
+   2: 0100007F:15B3 0100007F:D2A4 01 00000000:00000000 00:00000000 00000000     0        0 700 1 00000000ef83b787 100 0 0 10 0
+   3: garbage
+`
+	want := map[uint64]int{911: 3000, 662: 5555}
+	if diff := cmp.Diff(want, parseProcNetTCP([]byte(in))); diff != "" {
+		t.Fatalf("parseProcNetTCP() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestListeningPorts(t *testing.T) {
+	li, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+	port := li.Addr().(*net.TCPAddr).Port
+
+	ports, err := listeningPorts(os.Getpid())
+	if err != nil {
+		t.Skipf("procfs not available: %v", err)
+	}
+	for _, p := range ports {
+		if p == port {
+			return
+		}
+	}
+	t.Fatalf("port %d not found in %v", port, ports)
+}
+
+func TestListenPortsHint(t *testing.T) {
+	tests := []struct {
+		ports     []int
+		wantOther int
+		wantHint  string
+	}{
+		{nil, 0, " (it's not listening on any TCP port)"},
+		{[]int{5555}, 0, ""},
+		{[]int{3000}, 3000, " (it's listening on ports 3000 instead, make it listen on $PORT)"},
+		{[]int{3000, 9229}, 0, " (it's listening on ports 3000, 9229 instead, make it listen on $PORT)"},
+	}
+	for _, tt := range tests {
+		other, _ := otherListenPort(tt.ports, 5555)
+		if other != tt.wantOther {
+			t.Errorf("otherListenPort(%v) = %d, want %d", tt.ports, other, tt.wantOther)
+		}
+		if got := listenPortsHint(tt.ports, 5555); got != tt.wantHint {
+			t.Errorf("listenPortsHint(%v) = %q, want %q", tt.ports, got, tt.wantHint)
+		}
+	}
+}
@@ -19,12 +19,14 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"net"
+	"net/http"
 	"time"
 )
 
 const (
 	defaultPortRetryInterval = time.Millisecond * 5
 	defaultPortDialTimeout   = time.Millisecond * 40
+	defaultProbeTimeout      = time.Second * 2
 )
 
 type portChecker interface {
@@ -32,6 +34,23 @@ type portChecker interface {
 	waitPort(context.Context) error
 }
 
+// newPortChecker returns the readiness probe for the user process listening
+// on port.
+func newPortChecker(opts daemonOpts, port int) portChecker {
+	if opts.readyPath != "" {
+		return newHTTPPortChecker(port, opts.readyPath, opts.readyInterval)
+	}
+	return newTCPPortChecker(port)
+}
+
+// readyDesc describes what the user process is waited on to be ready.
+func readyDesc(opts daemonOpts, port int) string {
+	if opts.readyPath != "" {
+		return fmt.Sprintf("pass the readiness probe (GET %s on $PORT %d)", opts.readyPath, port)
+	}
+	return fmt.Sprintf("start listening on $PORT (%d)", port)
+}
+
 type tcpPortCheck struct {
 	portNum       int
 	retryInterval time.Duration
@@ -59,8 +78,7 @@ func (t *tcpPortCheck) checkPort() bool {
 // waitPort waits for port to be connectable until the specified ctx is cancelled.
 func (t *tcpPortCheck) waitPort(ctx context.Context) error {
 	// TODO: do we need to return error from this method?
-	ch := make(chan struct{}, 1)
-	defer close(ch)
+	ch := make(chan struct{}) // not closed, the poller may outlive this call when ctx is canceled
 
 	tick := time.NewTicker(t.retryInterval)
 	defer tick.Stop()
@@ -72,7 +90,10 @@ func (t *tcpPortCheck) waitPort(ctx context.Context) error {
 				return
 			case <-tick.C:
 				if ok := t.checkPort(); ok {
-					ch <- struct{}{}
+					select {
+					case ch <- struct{}{}:
+					case <-ctx.Done():
+					}
 					return
 				}
 				time.Sleep(time.Millisecond * 10)
@@ -87,3 +108,61 @@ func (t *tcpPortCheck) waitPort(ctx context.Context) error {
 		return errors.Wrap(ctx.Err(), "quit waiting on port to open")
 	}
 }
+
+// httpPortCheck considers the port ready when an HTTP GET request to the
+// path succeeds (responds with a 2xx or 3xx status).
+type httpPortCheck struct {
+	portNum      int
+	path         string
+	interval     time.Duration
+	probeTimeout time.Duration
+	client       *http.Client
+}
+
+func newHTTPPortChecker(port int, path string, interval time.Duration) portChecker {
+	return &httpPortCheck{
+		portNum:      port,
+		path:         path,
+		interval:     interval,
+		probeTimeout: defaultProbeTimeout,
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}}
+}
+
+func (h *httpPortCheck) checkPort() bool {
+	return h.probe(context.Background()) == nil
+}
+
+func (h *httpPortCheck) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, h.probeTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d%s", h.portNum, h.path), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return errors.Errorf("readiness probe responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// waitPort polls the path until it succeeds or the specified ctx is cancelled.
+func (h *httpPortCheck) waitPort(ctx context.Context) error {
+	var lastErr error
+	for {
+		if lastErr = h.probe(ctx); lastErr == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "quit waiting on readiness probe (last error: %v)", lastErr)
+		case <-time.After(h.interval):
+		}
+	}
+}
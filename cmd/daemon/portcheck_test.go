@@ -16,8 +16,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/pkg/errors"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -65,3 +69,61 @@ func Test_waitPort(t *testing.T) {
 		t.Fatalf("got error from open port: %v", err)
 	}
 }
+
+func Test_httpWaitPort(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	if newHTTPPortChecker(port, "/other", time.Millisecond).checkPort() {
+		t.Fatal("404 should not be considered ready")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := newHTTPPortChecker(port, "/healthz", time.Millisecond*10).waitPort(ctx); err != nil {
+		t.Fatalf("got error from readiness probe: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("expected 3 probes, got %d", got)
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel2()
+	err := newHTTPPortChecker(port, "/other", time.Millisecond*10).waitPort(ctx2)
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("inner error is not timeline exceeded: %+v", err)
+	}
+}
+
+func Test_waitPort_canceledWhilePolling(t *testing.T) {
+	li, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+	c := newTCPPortChecker(li.Addr().(*net.TCPAddr).Port).(*tcpPortCheck)
+	c.retryInterval = time.Millisecond
+
+	// cancel while the poller is between finding the port open and reporting it
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- c.waitPort(ctx) }()
+		time.Sleep(time.Duration(i%5) * time.Millisecond)
+		cancel()
+		<-done
+	}
+	time.Sleep(time.Millisecond * 50) // pollers still running must not panic
+}
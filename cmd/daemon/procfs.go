@@ -0,0 +1,197 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// procStat is the subset of /proc/PID/stat fields used to track processes.
+type procStat struct {
+	pid   int
+	ppid  int
+	pgrp  int
+	state byte // e.g. R (running), S (sleeping), Z (zombie)
+}
+
+// readProcs lists the processes from procfs. Processes that exit while
+// listing are skipped.
+func readProcs() ([]procStat, error) {
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list /proc")
+	} else if len(dirs) == 0 {
+		return nil, errors.New("procfs not available")
+	}
+	var out []procStat
+	for _, dir := range dirs {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			continue // process vanished
+		}
+		if s, ok := parseProcStat(b); ok {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// parseProcStat parses the contents of a /proc/PID/stat file, in the format of
+// "PID (COMM) STATE PPID PGRP ...". COMM can contain spaces and parentheses.
+func parseProcStat(b []byte) (procStat, bool) {
+	i, j := bytes.IndexByte(b, '('), bytes.LastIndexByte(b, ')')
+	if i < 0 || j < i {
+		return procStat{}, false
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(b[:i])))
+	if err != nil {
+		return procStat{}, false
+	}
+	f := bytes.Fields(b[j+1:])
+	if len(f) < 3 || len(f[0]) != 1 {
+		return procStat{}, false
+	}
+	ppid, err1 := strconv.Atoi(string(f[1]))
+	pgrp, err2 := strconv.Atoi(string(f[2]))
+	if err1 != nil || err2 != nil {
+		return procStat{}, false
+	}
+	return procStat{pid: pid, ppid: ppid, pgrp: pgrp, state: f[0][0]}, true
+}
+
+// processTree returns pid and its descendants, with children ordered before
+// their parents.
+func processTree(pid int, procs []procStat) []int {
+	children := make(map[int][]int)
+	for _, p := range procs {
+		children[p.ppid] = append(children[p.ppid], p.pid)
+	}
+	var out []int
+	var visit func(int)
+	visit = func(pid int) {
+		for _, c := range children[pid] {
+			visit(c)
+		}
+		out = append(out, pid)
+	}
+	visit(pid)
+	return out
+}
+
+// procGroup tracks the processes started by a child process: its process
+// group, and its descendants that may have moved to other groups or have been
+// re-parented after their parent exited.
+type procGroup struct {
+	pid  int
+	tree []int // descendants of pid (children first) when last listed
+}
+
+// watchProcs starts tracking the process group and descendants of pid, which
+// must be a process group leader.
+func watchProcs(pid int) *procGroup {
+	g := &procGroup{pid: pid}
+	g.refresh()
+	return g
+}
+
+// refresh adds the current descendants of the process to the tracked ones.
+func (g *procGroup) refresh() {
+	procs, err := readProcs()
+	if err != nil {
+		return
+	}
+	seen := make(map[int]bool)
+	var tree []int
+	for _, pid := range append(processTree(g.pid, procs), g.tree...) {
+		if !seen[pid] {
+			seen[pid] = true
+			tree = append(tree, pid)
+		}
+	}
+	g.tree = tree
+}
+
+// alive checks if any of the tracked processes have not exited. Unlike
+// signal 0, it does not count zombie processes waiting to be reaped.
+func (g *procGroup) alive() bool {
+	procs, err := readProcs()
+	if err != nil {
+		return syscall.Kill(-g.pid, 0) == nil
+	}
+	tracked := make(map[int]bool, len(g.tree))
+	for _, pid := range g.tree {
+		tracked[pid] = true
+	}
+	for _, p := range procs {
+		if p.state != 'Z' && (p.pgrp == g.pid || tracked[p.pid]) {
+			return true
+		}
+	}
+	return false
+}
+
+// wait polls until the tracked processes have exited, and reports whether
+// that happened before timeout.
+func (g *procGroup) wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for g.alive() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(groupExitPollInterval)
+	}
+	return true
+}
+
+// signal sends sig to the process group. If that fails (process group
+// signals are not supported on gVisor), the process tree is signaled instead.
+func (g *procGroup) signal(sig syscall.Signal) {
+	if err := syscall.Kill(-g.pid, sig); err != nil {
+		log.Printf("warning: failed to signal process group %d (%v), signaling process tree", g.pid, err)
+		g.signalTree(sig)
+	}
+}
+
+// signalTree sends sig to the tracked processes, children before parents.
+func (g *procGroup) signalTree(sig syscall.Signal) {
+	g.refresh()
+	for _, pid := range g.tree {
+		if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+			log.Printf("warning: failed to signal pid %d: %v", pid, err)
+		}
+	}
+}
+
+// kill sends SIGKILL to the process group, and to each process in the tree
+// if the group does not go away. It reports whether all tracked processes
+// have exited before timeout.
+func (g *procGroup) kill(timeout time.Duration) bool {
+	g.refresh()
+	deadline := time.Now().Add(timeout)
+	g.signal(syscall.SIGKILL)
+	if g.wait(groupKillTimeout) {
+		return true
+	}
+	log.Printf("warning: processes of pid %d still running after process group SIGKILL, killing process tree", g.pid)
+	g.signalTree(syscall.SIGKILL)
+	return g.wait(time.Until(deadline))
+}
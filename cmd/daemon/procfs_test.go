@@ -0,0 +1,98 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want procStat
+		ok   bool
+	}{
+		{"12 (node) S 7 7 7 0 -1", procStat{pid: 12, ppid: 7, pgrp: 7, state: 'S'}, true},
+		{"30 (tmux: server (1)) R 1 30 30", procStat{pid: 30, ppid: 1, pgrp: 30, state: 'R'}, true},
+		{"31 (sh) Z 12 7", procStat{pid: 31, ppid: 12, pgrp: 7, state: 'Z'}, true},
+		{"31 (sh) Z", procStat{}, false},
+		{"garbage", procStat{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseProcStat([]byte(tt.in))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseProcStat(%q)=%+v,%v want=%+v,%v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestProcessTree(t *testing.T) {
+	procs := []procStat{
+		{pid: 1, ppid: 0},
+		{pid: 10, ppid: 1},
+		{pid: 11, ppid: 10},
+		{pid: 12, ppid: 11},
+		{pid: 13, ppid: 10},
+		{pid: 20, ppid: 1},
+	}
+	got := processTree(10, procs)
+	want := []int{12, 11, 13, 10}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestKillEscapedDescendants(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	pidFile := filepath.Join(tmp, "pid")
+
+	// grandchild moves to a new process group, so it doesn't get the
+	// process group signal
+	n := newProcessNanny("/bin/sh", []string{"-c", "setsid sleep 100 & echo $! > " + pidFile + "; wait"}, procOpts{})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	var pid int
+	for i := 0; i < 100 && pid == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+		b, _ := ioutil.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if pid == 0 {
+		t.Fatal("grandchild did not start")
+	}
+	time.Sleep(time.Millisecond * 50)
+	n.Kill()
+
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err == nil {
+		if s, ok := parseProcStat(b); ok && s.state != 'Z' {
+			t.Fatalf("grandchild still running: %s", b)
+		}
+	}
+}
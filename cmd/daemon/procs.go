@@ -0,0 +1,156 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/pkg/errors"
+	"log"
+	"regexp"
+)
+
+// mainProcName is the name the user app is listed with among the named
+// processes.
+const mainProcName = "web"
+
+var procNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// procSpec is a named process with its options parsed.
+type procSpec struct {
+	types.Process
+	cond    buildCondition // restarts the process on matching changes, if not empty
+	restart restartPolicy
+}
+
+// namedProc is a process supervised along with the user app.
+type namedProc struct {
+	procSpec
+	logs  *logBuffer
+	nanny nanny
+}
+
+// parseProcs validates the named processes and parses their options.
+// Processes without a restart policy get defaultPolicy.
+func parseProcs(procs types.Processes, defaultPolicy restartPolicy) ([]procSpec, error) {
+	var out []procSpec
+	seen := map[string]bool{mainProcName: true}
+	for i, p := range procs {
+		if !procNamePattern.MatchString(p.Name) {
+			return nil, errors.Errorf("process #%d has invalid name %q", i, p.Name)
+		} else if seen[p.Name] {
+			return nil, errors.Errorf("process name %q is reserved or used more than once", p.Name)
+		}
+		seen[p.Name] = true
+		if len(p.C) == 0 {
+			return nil, errors.Errorf("process %q has no command", p.Name)
+		}
+		spec := procSpec{Process: p, restart: defaultPolicy}
+		if p.Restart != "" {
+			v, err := parseRestartPolicy(p.Restart)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid restart policy of process %q", p.Name)
+			}
+			spec.restart = v
+		}
+		cond, err := parseBuildCondition(p.On)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid file patterns of process %q", p.Name)
+		}
+		spec.cond = cond
+		out = append(out, spec)
+	}
+	return out, nil
+}
+
+func newNamedProcs(opts daemonOpts, events *eventLog) []*namedProc {
+	var out []*namedProc
+	for _, spec := range opts.procs {
+		logs := new(logBuffer)
+		out = append(out, &namedProc{
+			procSpec: spec,
+			logs:     logs,
+			nanny: newProcessNanny(spec.C.Command(), spec.C.Args(), procOpts{
+				name:           spec.Name,
+				port:           spec.Port,
+				dir:            opts.workDir,
+				logs:           logs,
+				events:         events,
+				stopSignal:     opts.stopSignal,
+				gracePeriod:    opts.stopGracePeriod,
+				restartPolicy:  spec.restart,
+				restartBackoff: opts.restartBackoff,
+			}),
+		})
+	}
+	return out
+}
+
+// startProcs starts the named processes that are not running, unless they're
+// crash looping. It must be called while holding the nannyLock.
+func (srv *daemonServer) startProcs() {
+	for _, p := range srv.procs {
+		if p.nanny.Running() || p.nanny.CrashLooping() {
+			continue
+		}
+		log.Printf("[procs] starting process %q", p.Name)
+		if err := p.nanny.Restart(); err != nil {
+			log.Printf("[warn] failed to start process %q: %+v", p.Name, err)
+		}
+	}
+}
+
+// bootProcs starts the named processes when rundevd starts, without waiting
+// for a request to start the user app.
+func (srv *daemonServer) bootProcs() {
+	srv.nannyLock.Lock()
+	defer srv.nannyLock.Unlock()
+	srv.startProcs()
+}
+
+// stopChangedProcs stops the named processes with file patterns matching the
+// changes, so they're restarted with the user app. Processes without file
+// patterns, like asset watchers, keep running. It must be called while
+// holding the nannyLock.
+func (srv *daemonServer) stopChangedProcs(changes []fsutil.Change) {
+	for _, p := range srv.procs {
+		if len(p.cond) == 0 {
+			continue
+		}
+		for _, c := range changes {
+			if p.cond.matches(c) {
+				log.Printf("[procs] stopping process %q, %s matches its file patterns", p.Name, c)
+				p.nanny.Kill()
+				break
+			}
+		}
+	}
+}
+
+// findProc returns the named process, or nil if there's none.
+func (srv *daemonServer) findProc(name string) *namedProc {
+	for _, p := range srv.procs {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (p *namedProc) String() string {
+	return fmt.Sprintf("%s: running=%v pid=%d port=%d restart=%s on=%v cmd=%v",
+		p.Name, p.nanny.Running(), p.nanny.Pid(), p.Port, p.restart, p.On, p.C)
+}
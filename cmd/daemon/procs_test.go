@@ -0,0 +1,100 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"testing"
+)
+
+func TestParseProcs(t *testing.T) {
+	tests := []struct {
+		name    string
+		procs   types.Processes
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", procs: types.Processes{
+			{Name: "worker", C: types.Cmd{"./worker"}, On: []string{"**/*.go"}, Restart: "always"},
+			{Name: "css", C: types.Cmd{"tailwindcss", "--watch"}},
+		}},
+		{name: "reserved name", procs: types.Processes{{Name: mainProcName, C: types.Cmd{"x"}}}, wantErr: true},
+		{name: "duplicate", procs: types.Processes{{Name: "a", C: types.Cmd{"x"}}, {Name: "a", C: types.Cmd{"y"}}}, wantErr: true},
+		{name: "invalid name", procs: types.Processes{{Name: "a b", C: types.Cmd{"x"}}}, wantErr: true},
+		{name: "no command", procs: types.Processes{{Name: "a"}}, wantErr: true},
+		{name: "invalid restart policy", procs: types.Processes{{Name: "a", C: types.Cmd{"x"}, Restart: "sometimes"}}, wantErr: true},
+		{name: "invalid pattern", procs: types.Processes{{Name: "a", C: types.Cmd{"x"}, On: []string{"[a-"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcs(tt.procs, restartOnFailure)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProcs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(got) != len(tt.procs) {
+				t.Fatalf("got %d procs, expected %d", len(got), len(tt.procs))
+			}
+		})
+	}
+}
+
+func TestParseProcs_defaultRestartPolicy(t *testing.T) {
+	got, err := parseProcs(types.Processes{
+		{Name: "a", C: types.Cmd{"x"}},
+		{Name: "b", C: types.Cmd{"x"}, Restart: "always"},
+	}, restartOnFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].restart != restartOnFailure || got[1].restart != restartAlways {
+		t.Fatalf("unexpected restart policies: %s, %s", got[0].restart, got[1].restart)
+	}
+}
+
+func TestStopChangedProcs(t *testing.T) {
+	specs, err := parseProcs(types.Processes{
+		{Name: "worker", C: types.Cmd{"x"}, On: []string{"worker/**"}},
+		{Name: "css", C: types.Cmd{"x"}},
+	}, restartNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker, css := new(fakeNanny), new(fakeNanny)
+	srv := &daemonServer{procs: []*namedProc{
+		{procSpec: specs[0], logs: new(logBuffer), nanny: worker},
+		{procSpec: specs[1], logs: new(logBuffer), nanny: css},
+	}}
+
+	srv.bootProcs()
+	if !worker.Running() || !css.Running() {
+		t.Fatal("processes not started")
+	}
+	srv.stopChangedProcs([]fsutil.Change{{Path: "web/app.go", Type: fsutil.ChangeModified}})
+	if !worker.Running() || !css.Running() {
+		t.Fatal("processes should not stop on unrelated changes")
+	}
+	srv.stopChangedProcs([]fsutil.Change{{Path: "worker/main.go", Type: fsutil.ChangeModified}})
+	if worker.Running() {
+		t.Fatal("worker should stop on matching changes")
+	}
+	if !css.Running() {
+		t.Fatal("processes without file patterns should keep running")
+	}
+	srv.startProcs()
+	if !worker.Running() || worker.restarts != 2 || css.restarts != 1 {
+		t.Fatalf("unexpected restarts: worker=%d css=%d", worker.restarts, css.restarts)
+	}
+}
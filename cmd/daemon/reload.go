@@ -0,0 +1,142 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/signals"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/pkg/errors"
+	"log"
+	"syscall"
+)
+
+// reloadAction is how the user process picks up changed files, in increasing
+// order of cost.
+type reloadAction int
+
+const (
+	reloadNone    reloadAction = iota // the app picks up the changes on its own
+	reloadSignal                      // the app reloads on a signal, e.g. SIGHUP
+	reloadRestart                     // the app is restarted without running the build cmds
+	reloadRebuild                     // the build cmds are run and the app is restarted
+)
+
+var reloadActions = map[string]reloadAction{
+	"none":    reloadNone,
+	"signal":  reloadSignal,
+	"restart": reloadRestart,
+	"rebuild": reloadRebuild,
+}
+
+func (a reloadAction) String() string {
+	for k, v := range reloadActions {
+		if v == a {
+			return k
+		}
+	}
+	return "unknown"
+}
+
+// reloadRule is a reload rule with its options parsed.
+type reloadRule struct {
+	on     []string
+	cond   buildCondition
+	action reloadAction
+	signal syscall.Signal
+}
+
+func parseReloadRules(rules types.ReloadRules) ([]reloadRule, error) {
+	var out []reloadRule
+	for i, r := range rules {
+		action, ok := reloadActions[r.Action]
+		if !ok {
+			return nil, errors.Errorf("reload rule #%d: unknown action %q (valid values: none, signal, restart, rebuild)", i, r.Action)
+		}
+		if len(r.On) == 0 {
+			return nil, errors.Errorf("reload rule #%d: no file patterns", i)
+		}
+		cond, err := parseBuildCondition(r.On)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reload rule #%d: invalid file patterns", i)
+		}
+		rule := reloadRule{on: r.On, cond: cond, action: action, signal: syscall.SIGHUP}
+		if r.Signal != "" {
+			if action != reloadSignal {
+				return nil, errors.Errorf("reload rule #%d: signal is only used with the signal action", i)
+			}
+			if rule.signal, err = signals.Parse(r.Signal); err != nil {
+				return nil, errors.Wrapf(err, "reload rule #%d", i)
+			}
+		}
+		out = append(out, rule)
+	}
+	return out, nil
+}
+
+// reloadFor returns the action that picks up all the changes, which is the
+// costliest of the actions of the first rule matching each change. Changes
+// not matching any rule need a rebuild.
+func reloadFor(rules []reloadRule, changes []fsutil.Change) (reloadAction, syscall.Signal) {
+	out, sig := reloadNone, syscall.Signal(0)
+	for _, c := range changes {
+		action := reloadRebuild
+		for _, r := range rules {
+			if r.cond.matches(c) {
+				action = r.action
+				if action == reloadSignal && sig == 0 {
+					sig = r.signal
+				}
+				break
+			}
+		}
+		if action > out {
+			out = action
+		}
+	}
+	return out, sig
+}
+
+// reload applies the action for the changes in a patch to the user process.
+// The tree is at checksum sum after the patch. It must be called while
+// holding the nannyLock.
+func (srv *daemonServer) reload(action reloadAction, sig syscall.Signal, sum uint64) {
+	running := srv.procNanny.Running()
+	switch {
+	case action == reloadRebuild:
+		if srv.opts.blueGreen && running {
+			log.Printf("patch applied, keeping the process serving until the new version starts")
+			return
+		}
+		log.Printf("patch applied, killing process")
+		srv.procNanny.Kill() // restart the process on next proxied request
+		srv.noBuild = false
+	case !running:
+		// started on the next request, with the action of an earlier patch
+	case action == reloadRestart:
+		log.Printf("patch applied, killing process (restarting without build)")
+		srv.procNanny.Kill()
+		srv.noBuild = true
+	case action == reloadSignal:
+		log.Printf("patch applied, sending %v to process", sig)
+		if err := srv.procNanny.Signal(sig); err != nil {
+			log.Printf("[warn] %+v", err)
+		}
+		srv.runningSum = sum
+	default:
+		log.Printf("patch applied, not reloading the process")
+		srv.runningSum = sum
+	}
+}
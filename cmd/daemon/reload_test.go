@@ -0,0 +1,125 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestParseReloadRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   types.ReloadRules
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", rules: types.ReloadRules{
+			{On: []string{"static/**"}, Action: "none"},
+			{On: []string{"**/*.conf"}, Action: "signal", Signal: "SIGUSR2"},
+			{On: []string{"templates/**"}, Action: "restart"},
+		}},
+		{name: "unknown action", rules: types.ReloadRules{{On: []string{"*"}, Action: "reboot"}}, wantErr: true},
+		{name: "no patterns", rules: types.ReloadRules{{Action: "none"}}, wantErr: true},
+		{name: "signal with restart", rules: types.ReloadRules{{On: []string{"*"}, Action: "restart", Signal: "HUP"}}, wantErr: true},
+		{name: "unknown signal", rules: types.ReloadRules{{On: []string{"*"}, Action: "signal", Signal: "SIGFOO"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseReloadRules(tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReloadRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloadFor(t *testing.T) {
+	rules, err := parseReloadRules(types.ReloadRules{
+		{On: []string{"static/**", "**/*.css"}, Action: "none"},
+		{On: []string{"nginx.conf"}, Action: "signal"},
+		{On: []string{"templates/**"}, Action: "restart"},
+		{On: []string{"**/*.html"}, Action: "none"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		changes []string
+		want    reloadAction
+		wantSig syscall.Signal
+	}{
+		{"static files", []string{"static/app.js", "web/style.css"}, reloadNone, 0},
+		{"signal", []string{"static/app.js", "nginx.conf"}, reloadSignal, syscall.SIGHUP},
+		{"first matching rule wins", []string{"templates/index.html"}, reloadRestart, 0},
+		{"costliest action wins", []string{"nginx.conf", "templates/a.tmpl"}, reloadRestart, syscall.SIGHUP},
+		{"no matching rule", []string{"static/app.js", "main.go"}, reloadRebuild, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes []fsutil.Change
+			for _, f := range tt.changes {
+				changes = append(changes, fsutil.Change{Path: f, Type: fsutil.ChangeModified})
+			}
+			got, sig := reloadFor(rules, changes)
+			if got != tt.want || sig != tt.wantSig {
+				t.Fatalf("reloadFor() = %s, %v, want %s, %v", got, sig, tt.want, tt.wantSig)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	srv, n, tmp := testStartServer(t, "echo built >> builds")
+	defer os.RemoveAll(tmp)
+	fs := []fsutil.FSNode{{Name: "a"}}
+	start := func() {
+		op := srv.startOnce(fs)
+		<-op.done
+		if op.procErr != nil {
+			t.Fatal(op.procErr)
+		}
+	}
+	start()
+
+	srv.reload(reloadNone, 0, 1)
+	srv.reload(reloadSignal, syscall.SIGHUP, 2)
+	if !n.Running() || len(n.signals) != 1 || n.signals[0] != syscall.SIGHUP {
+		t.Fatalf("expected process to keep running and get SIGHUP, got running=%v signals=%v", n.Running(), n.signals)
+	}
+	if srv.runningSum != 2 {
+		t.Fatalf("running process should be up to date with the tree, got checksum %d", srv.runningSum)
+	}
+
+	srv.reload(reloadRestart, 0, 0)
+	if n.Running() || !srv.noBuild {
+		t.Fatal("expected process to stop for a restart without build")
+	}
+	start()
+	if n.restarts != 2 || len(srv.lastBuild.steps) != 1 {
+		t.Fatalf("expected restart without build, got restarts=%d build steps=%d", n.restarts, len(srv.lastBuild.steps))
+	}
+
+	srv.reload(reloadRestart, 0, 0)
+	srv.reload(reloadRebuild, 0, 0) // overrides the earlier restart
+	srv.reload(reloadRestart, 0, 0) // does not override the pending rebuild
+	if srv.noBuild {
+		t.Fatal("pending rebuild was overridden")
+	}
+}
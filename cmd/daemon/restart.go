@@ -0,0 +1,129 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	crashLoopThreshold = 5                // consecutive crashes before giving up restarting
+	crashMinUptime     = time.Second * 10 // processes exiting sooner are counted as crashes
+	restartBackoffMax  = time.Second * 30
+	exitHistorySize    = 10
+	exitLogLines       = 20
+)
+
+// restartPolicy decides whether the user process is restarted after it exits
+// on its own.
+type restartPolicy string
+
+const (
+	restartNever     restartPolicy = "never" // restarted on the next request
+	restartOnFailure restartPolicy = "on-failure"
+	restartAlways    restartPolicy = "always"
+)
+
+func parseRestartPolicy(s string) (restartPolicy, error) {
+	switch p := restartPolicy(s); p {
+	case restartNever, restartOnFailure, restartAlways:
+		return p, nil
+	}
+	return "", errors.Errorf("unknown restart policy %q (valid values: %s, %s, %s)", s, restartNever, restartOnFailure, restartAlways)
+}
+
+func (r restartPolicy) restarts(e *procExit) bool {
+	switch r {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return e.code != 0
+	default:
+		return false
+	}
+}
+
+// restartBackoff returns the delay before restarting a process that has
+// crashed the given number of times in a row.
+func restartBackoff(base time.Duration, crashes int) time.Duration {
+	d := base
+	for i := 1; i < crashes && d < restartBackoffMax; i++ {
+		d *= 2
+	}
+	if d > restartBackoffMax {
+		d = restartBackoffMax
+	}
+	return d
+}
+
+// recordExit records the exit of a process that was not stopped by the nanny,
+// detects crash loops, and schedules a restart based on the restart policy.
+// It must be called while holding the lock.
+func (p *procNanny) recordExit(h *procHandle, e *procExit) {
+	p.exits = append(p.exits, *e)
+	if len(p.exits) > exitHistorySize {
+		p.exits = p.exits[len(p.exits)-exitHistorySize:]
+	}
+
+	if e.uptime < crashMinUptime {
+		p.crashes++
+	} else {
+		p.crashes = 0
+	}
+	if p.crashes >= crashLoopThreshold {
+		log.Printf("process crashed %d times in a row, not restarting until the next patch", p.crashes)
+		p.crashLooping = true
+		return
+	}
+	if p.proc != h || !p.opts.restartPolicy.restarts(e) {
+		return
+	}
+
+	delay := restartBackoff(p.opts.restartBackoff, p.crashes)
+	log.Printf("restarting process in %v (restart policy: %s)", delay, p.opts.restartPolicy)
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		p.startMu.Lock() // Restart and Kill stop the timer while holding it
+		defer p.startMu.Unlock()
+		p.mu.Lock()
+		ok := p.restartTimer == t // not restarted or killed in the meantime
+		if ok {
+			p.restartTimer = nil
+		}
+		p.mu.Unlock()
+		if !ok {
+			return
+		}
+		if err := p.replace(); err != nil {
+			log.Printf("warning: failed to restart process: %+v", err)
+		}
+	})
+	p.restartTimer = t
+}
+
+// tailLines returns the last n lines of s.
+func tailLines(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
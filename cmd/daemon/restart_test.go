@@ -0,0 +1,174 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	tests := []struct {
+		crashes int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{10, restartBackoffMax},
+	}
+	for _, tt := range tests {
+		if got := restartBackoff(time.Second, tt.crashes); got != tt.want {
+			t.Errorf("restartBackoff(%d)=%v, want=%v", tt.crashes, got, tt.want)
+		}
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"", 2, ""},
+		{"a\nb\nc\n", 2, "b\nc"},
+		{"a\nb", 5, "a\nb"},
+		{"a\nb", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := tailLines(tt.in, tt.n); got != tt.want {
+			t.Errorf("tailLines(%q, %d)=%q, want=%q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestRestartOnFailure_crashLoop(t *testing.T) {
+	n := newProcessNanny("/bin/sh", []string{"-c", "echo crashing; exit 2"}, procOpts{
+		logs:           new(logBuffer),
+		restartPolicy:  restartOnFailure,
+		restartBackoff: time.Millisecond * 5,
+	})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, n.CrashLooping)
+
+	exits := n.Exits()
+	if len(exits) != crashLoopThreshold {
+		t.Fatalf("got %d exits, expected %d", len(exits), crashLoopThreshold)
+	}
+	for i, e := range exits {
+		if e.incarnation != i+1 || e.code != 2 || e.logTail != "crashing" {
+			t.Fatalf("unexpected exit record: %+v", e)
+		}
+	}
+	if n.Running() {
+		t.Fatal("crash looping process should not be restarted")
+	}
+
+	n.Kill()
+	if n.CrashLooping() {
+		t.Fatal("kill should clear the crash loop")
+	}
+}
+
+func TestRestartOnFailure_successfulExit(t *testing.T) {
+	n := newProcessNanny("/bin/sh", []string{"-c", "exit 0"}, procOpts{
+		restartPolicy:  restartOnFailure,
+		restartBackoff: time.Millisecond,
+	})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, func() bool { return len(n.Exits()) > 0 })
+	time.Sleep(time.Millisecond * 100)
+	if got := len(n.Exits()); got != 1 {
+		t.Fatalf("process exiting successfully was restarted (%d exits)", got)
+	}
+}
+
+func TestRestartAlways(t *testing.T) {
+	n := newProcessNanny("/bin/sh", []string{"-c", "exit 0"}, procOpts{
+		restartPolicy:  restartAlways,
+		restartBackoff: time.Millisecond,
+	})
+	defer n.Kill()
+	if err := n.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second*5, func() bool { return len(n.Exits()) >= 2 })
+}
+
+func TestRestartAfterExit_notInterleavedWithRestart(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	marker := filepath.Join(tmp, "started")
+	events := new(eventLog)
+	// exits right away the first time, keeps running afterwards
+	n := newProcessNanny("/bin/sh", []string{"-c", `if [ -f "$0" ]; then exec sleep 100; fi; touch "$0"`, marker}, procOpts{
+		events:         events,
+		restartPolicy:  restartAlways,
+		restartBackoff: time.Millisecond,
+	})
+	for i := 0; i < 20; i++ {
+		os.Remove(marker)
+		if err := n.Restart(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Duration(i%4) * time.Millisecond) // around the backoff restart
+		var wg sync.WaitGroup
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := n.Restart(); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	n.Kill()
+
+	all, _ := events.since(0)
+	for _, e := range all {
+		if e.Type == types.EventProcessStarted && syscall.Kill(e.Pid, 0) == nil {
+			t.Fatalf("pid %d (#%d) still running after Kill", e.Pid, e.Incarnation)
+		}
+	}
+}
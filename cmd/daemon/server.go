@@ -16,7 +16,6 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"crypto/subtle"
 	"encoding/json"
 	"fmt"
@@ -24,9 +23,7 @@ import (
 	"github.com/ahmetb/rundev/lib/constants"
 	"github.com/ahmetb/rundev/lib/fsutil"
 	"github.com/ahmetb/rundev/lib/handlerutil"
-	"github.com/ahmetb/rundev/lib/ignore"
 	"github.com/ahmetb/rundev/lib/types"
-	"github.com/bmatcuk/doublestar"
 	"github.com/google/uuid"
 	"github.com/kr/pretty"
 	"github.com/pkg/errors"
@@ -34,7 +31,6 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
@@ -50,52 +46,109 @@ type cmd struct {
 
 type daemonOpts struct {
 	clientSecret    string
-	syncDir         string
+	roots           []syncRoot
+	workDir         string
 	runCmd          types.Cmd
+	procs           []procSpec // named processes supervised along with the user app
 	buildCmds       types.BuildCmds
+	buildConditions []buildCondition // parsed from buildCmds
+	reloadRules     []reloadRule     // how changes are picked up, rebuild if no rule matches
 	childPort       int
-	ignores         *ignore.FileIgnores
 	portWaitTimeout time.Duration
+	readyPath       string // HTTP readiness probe, port is checked with TCP if empty
+	readyInterval   time.Duration
+	eagerBuild      bool // start building right after a patch
+	worker          bool // user app doesn't listen on a port, start it on boot and after patches
+	stopSignal      syscall.Signal
+	stopGracePeriod time.Duration
+	restartPolicy   restartPolicy
+	restartBackoff  time.Duration
+	blueGreen       bool // start new versions on altPort and switch over once they listen
+	altPort         int
 }
 
 type daemonServer struct {
-	opts        daemonOpts
-	incarnation string
-	portCheck   portChecker
-
-	procLogs         *bytes.Buffer
-	patchLock        sync.RWMutex
-	lastUpdatedFiles []string
-
-	nannyLock sync.Mutex
-	procNanny nanny
+	http.Handler
+
+	opts         daemonOpts
+	incarnation  string
+	portCheck    portChecker
+	altPortCheck portChecker // nil unless -blue-green
+
+	procLogs       *logBuffer
+	buildLogs      *logBuffer // of the latest build
+	events         *eventLog
+	patchLock      sync.RWMutex
+	pendingChanges *pendingChanges
+	builds         *buildTracker
+
+	startLock   sync.Mutex
+	startOp     *startOp // in-flight, shared by the requests waiting on it
+	lastBuild   *buildRecord
+	swapFailure *swapFailure // of the latest tree, if its version failed to start
+	listenPort  int          // detected port of a user process not listening on $PORT
+
+	nannyLock  sync.Mutex
+	procNanny  nanny
+	procs      []*namedProc
+	runningSum uint64 // checksum of the tree the user process was built from
+	noBuild    bool   // user process was stopped for changes not needing a build
 }
 
-func newDaemonServer(opts daemonOpts) http.Handler {
-	logs := new(bytes.Buffer)
+func newDaemonServer(opts daemonOpts) *daemonServer {
+	logs, events := new(logBuffer), new(eventLog)
 	r := &daemonServer{
-		opts:        opts,
-		incarnation: uuid.New().String(),
-		procLogs:    logs,
-		portCheck:   newTCPPortChecker(opts.childPort),
+		opts:           opts,
+		incarnation:    uuid.New().String(),
+		procLogs:       logs,
+		buildLogs:      new(logBuffer),
+		events:         events,
+		pendingChanges: newPendingChanges(),
+		builds:         new(buildTracker),
+		portCheck:      newPortChecker(opts, opts.childPort),
 		procNanny: newProcessNanny(opts.runCmd.Command(), opts.runCmd.Args(), procOpts{
-			port: opts.childPort,
-			dir:  opts.syncDir,
-			logs: logs,
+			name:           mainProcName,
+			port:           opts.childPort,
+			dir:            opts.workDir,
+			logs:           logs,
+			events:         events,
+			stopSignal:     opts.stopSignal,
+			gracePeriod:    opts.stopGracePeriod,
+			restartPolicy:  opts.restartPolicy,
+			restartBackoff: opts.restartBackoff,
 		}),
 	}
+	r.procs = newNamedProcs(opts, events)
+	if opts.blueGreen {
+		r.altPortCheck = newPortChecker(opts, opts.altPort)
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/rundevd/fsz", handlerutil.NewFSDebugHandler(r.opts.syncDir, r.opts.ignores))
+	mux.HandleFunc("/rundevd/fsz", handlerutil.NewFSDebugHandler(fsDebugRoots(r.opts.roots)))
 	mux.HandleFunc("/rundevd/debugz", r.statusHandler)
 	mux.HandleFunc("/rundevd/procz", r.logsHandler)
 	mux.HandleFunc("/rundevd/pstree", r.psHandler)
 	mux.HandleFunc("/rundevd/restart", r.restartHandler)
 	mux.HandleFunc("/rundevd/kill", r.killHandler)
 	mux.HandleFunc("/rundevd/patch", withClientSecretAuth(opts.clientSecret, r.patch))
+	mux.HandleFunc("/rundevd/sync", withClientSecretAuth(opts.clientSecret, r.syncHandler))
+	mux.HandleFunc("/rundevd/follow", withClientSecretAuth(opts.clientSecret, r.followHandler))
+	mux.HandleFunc("/rundevd/events", withClientSecretAuth(opts.clientSecret, r.eventsHandler))
 	mux.HandleFunc("/rundevd/", handlerutil.NewUnsupportedDebugEndpointHandler())
 	mux.HandleFunc("/", r.reverseProxyHandler)
-	return mux
+	r.Handler = mux
+	return r
+}
+
+// stopProcess stops the user process and the named processes, if they're
+// running.
+func (srv *daemonServer) stopProcess() {
+	srv.nannyLock.Lock()
+	defer srv.nannyLock.Unlock()
+	srv.procNanny.Kill()
+	for _, p := range srv.procs {
+		p.nanny.Kill()
+	}
 }
 
 func withClientSecretAuth(secret string, hand http.HandlerFunc) http.HandlerFunc {
@@ -118,18 +171,26 @@ func withClientSecretAuth(secret string, hand http.HandlerFunc) http.HandlerFunc
 }
 
 func (srv *daemonServer) reverseProxyHandler(w http.ResponseWriter, req *http.Request) {
-	srv.patchLock.RLock()
-	defer srv.patchLock.RUnlock()
-
 	id := uuid.New().String()
 	rr := &responseRecorder{rw: w}
 	w = rr
 	start := time.Now()
 	log.Printf("[rev proxy] request %s accepted: path=%s method=%s", id, req.URL.Path, req.Method)
+	proxied := false
 	defer func() {
 		log.Printf("[rev proxy] request %s complete: path=%s status=%d took=%v", id, req.URL.Path, rr.statusCode, time.Since(start))
+		if proxied {
+			srv.events.emit(types.Event{
+				Type:       types.EventRequestProxied,
+				Method:     req.Method,
+				Path:       req.URL.Path,
+				StatusCode: rr.statusCode,
+				Duration:   time.Since(start),
+			})
+		}
 	}()
 
+	w.Header().Set(constants.HdrRundevIncarnation, srv.incarnation)
 	reqChecksumHdr := req.Header.Get(constants.HdrRundevChecksum)
 	if reqChecksumHdr == "" {
 		writeErrorResp(w, http.StatusBadRequest, errors.Errorf("missing %s header from the client", constants.HdrRundevChecksum))
@@ -141,68 +202,75 @@ func (srv *daemonServer) reverseProxyHandler(w http.ResponseWriter, req *http.Re
 		return
 	}
 
-	fs, err := fsutil.Walk(srv.opts.syncDir, srv.opts.ignores)
-	if err != nil {
-		writeErrorResp(w, http.StatusInternalServerError, errors.Wrap(err, "failed to walk the sync directory"))
-		return
-	}
-	respChecksum := fs.RootChecksum()
-	w.Header().Set(constants.HdrRundevChecksum, fmt.Sprintf("%d", respChecksum))
-
-	if respChecksum != reqChecksum {
-		writeChecksumMismatchResp(w, fs)
-		return
-	}
-	srv.nannyLock.Lock()
-	if !srv.procNanny.Running() {
-		log.Printf("[rev proxy] user process not running, restarting")
-		executed := 0
-		for i, bc := range srv.opts.buildCmds {
-			log.Printf("[build] build cmd (%d of %d): %v", i, len(srv.opts.buildCmds), bc)
-			if len(bc.On) > 0 && !matches(srv.lastUpdatedFiles, bc.On) {
-				log.Println("[build] updates files don't match, skip")
-				continue
-			}
-
-			log.Println("[build] executing build command")
-			cmd := exec.Command(bc.C.Command(), bc.C.Args()...)
-			cmd.Dir = srv.opts.syncDir
-			if b, err := cmd.CombinedOutput(); err != nil {
-				srv.nannyLock.Unlock()
-				log.Printf("[build] build cmd failure: %s", string(b))
-				writeProcError(w, fmt.Sprintf("executing -build-cmd (%v) failed: %s", bc, err), b)
-				return
-			}
-			executed++
+	var swapErr *types.ProcError
+	for restarts := 0; ; restarts++ {
+		srv.patchLock.RLock()
+		fs, err := walkRoots(srv.opts.roots)
+		if err != nil {
+			srv.patchLock.RUnlock()
+			writeErrorResp(w, http.StatusInternalServerError, errors.Wrap(err, "failed to walk the sync directories"))
+			return
 		}
-		log.Printf("executed %d of %d build cmds", executed, len(srv.opts.buildCmds))
-
-		if err := srv.procNanny.Restart(); err != nil {
-			// TODO return structured response for errors
-			writeProcError(w, fmt.Sprintf("failed to start child process: %+v", err), srv.procLogs.Bytes())
-			srv.nannyLock.Unlock()
+		respChecksum := fsutil.CombinedChecksum(fs)
+		w.Header().Set(constants.HdrRundevChecksum, fmt.Sprintf("%d", respChecksum))
+
+		// after a build is canceled by a newer patch, the request is served
+		// from the new tree
+		if restarts == 0 && respChecksum != reqChecksum {
+			srv.patchLock.RUnlock()
+			writeChecksumMismatchResp(w, fs)
 			return
 		}
+		op := srv.startOnce(fs)
+		var interstitial <-chan time.Time // browsers get a page while the app is starting
+		if acceptsHTML(req) {
+			interstitial = time.After(interstitialDelay)
+		}
+		select {
+		case <-op.done:
+		case <-op.swapping:
+		case <-req.Context().Done():
+			srv.patchLock.RUnlock()
+			writeErrorResp(w, http.StatusServiceUnavailable, errors.New("request canceled while waiting for the app to start"))
+			return
+		case <-interstitial:
+			srv.patchLock.RUnlock()
+			srv.writeStartingPage(w, req, op)
+			return
+		}
+		if !op.finished() {
+			log.Printf("[rev proxy] new version is starting, previous version serves the request")
+			break
+		}
+		if op.canceled {
+			srv.patchLock.RUnlock() // let the patch apply
+			log.Printf("[rev proxy] build canceled by a newer patch, restarting build")
+			continue
+		} else if op.notReady && acceptsHTML(req) {
+			srv.patchLock.RUnlock()
+			srv.writeStartingPage(w, req, op) // reloads to keep waiting
+			return
+		} else if op.procErr != nil {
+			srv.patchLock.RUnlock()
+			writeProcError(w, op.procErr.Message, []byte(op.procErr.Output))
+			return
+		}
+		swapErr = op.swapErr
+		break
 	}
-	srv.nannyLock.Unlock()
+	defer srv.patchLock.RUnlock()
 
-	// wait for port to open
-	ctx, cancel := context.WithTimeout(req.Context(), srv.opts.portWaitTimeout)
-	defer cancel()
-	if err := srv.portCheck.waitPort(ctx); err != nil {
-		writeProcError(w, fmt.Sprintf("child process did not start listening on $PORT (%d) in %v", srv.opts.childPort, srv.opts.portWaitTimeout), srv.procLogs.Bytes())
-		return
-	}
 	log.Println("[rev proxy] app port is ready, proxying")
 
-	req.Host = fmt.Sprintf("localhost:%d", srv.opts.childPort)
+	port := srv.appPort()
+	req.Host = fmt.Sprintf("localhost:%d", port)
 
 	reqPath := req.URL.Path
 	if req.URL.RawQuery != "" {
 		reqPath += "?" + req.URL.RawQuery
 	}
 	defer req.Body.Close()
-	preq, err := http.NewRequest(req.Method, fmt.Sprintf("http://localhost:%d"+reqPath, srv.opts.childPort), req.Body)
+	preq, err := http.NewRequest(req.Method, fmt.Sprintf("http://localhost:%d"+reqPath, port), req.Body)
 	if err != nil {
 		writeProcError(w, fmt.Sprintf("failed to create reverse proxy request in rundevd:\nerror: %+v", err), nil)
 		return
@@ -216,6 +284,11 @@ func (srv *daemonServer) reverseProxyHandler(w http.ResponseWriter, req *http.Re
 	}
 	// copy the response
 	defer resp.Body.Close()
+	proxied = true
+	if swapErr != nil {
+		writeWithSwapBanner(w, resp, swapErr)
+		return
+	}
 	for k, vals := range resp.Header {
 		for _, v := range vals {
 			w.Header().Add(k, v)
@@ -225,6 +298,17 @@ func (srv *daemonServer) reverseProxyHandler(w http.ResponseWriter, req *http.Re
 	_, _ = io.Copy(w, resp.Body)
 }
 
+// patchApplies checks if a patch from the precondition checksum to the
+// incoming checksum would be applied to the current tree of the sync dir.
+func patchApplies(root syncRoot, preconditionSum, incomingSum string) bool {
+	fs, err := fsutil.Walk(root.Remote, root.ignores)
+	if err != nil {
+		return false
+	}
+	sum := fmt.Sprintf("%d", fs.RootChecksum())
+	return sum == preconditionSum && sum != incomingSum
+}
+
 func (srv *daemonServer) patch(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPatch {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -249,11 +333,30 @@ func (srv *daemonServer) patch(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	var mountIdx int
+	if v := req.Header.Get(constants.HdrRundevMount); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || i >= len(srv.opts.roots) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "invalid %s header %q (%d sync dirs configured)", constants.HdrRundevMount, v, len(srv.opts.roots))
+			return
+		}
+		mountIdx = i
+	}
+	root := srv.opts.roots[mountIdx]
+
+	// cancel the build of the previous tree, which would otherwise hold up
+	// applying the patch, unless the patch is a no-op or won't apply (checked
+	// again once the tree can no longer change)
+	if sum, err := strconv.ParseUint(incomingChecksum, 10, 64); err == nil && patchApplies(root, expectedLocalChecksum, incomingChecksum) {
+		defer srv.builds.patching(mountIdx, sum)()
+	}
+
 	// stop accepting new proxy or patch requests while potentially modifying fs
 	srv.patchLock.Lock()
 	defer srv.patchLock.Unlock()
 
-	fs, err := fsutil.Walk(srv.opts.syncDir, srv.opts.ignores)
+	fs, err := fsutil.Walk(root.Remote, root.ignores)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "failed to fetch local filesystem: %+v", err)
@@ -266,35 +369,80 @@ func (srv *daemonServer) patch(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	if localChecksum != expectedLocalChecksum {
-		w.WriteHeader(http.StatusPreconditionFailed)
 		w.Header().Set(constants.HdrRundevChecksum, localChecksum)
+		w.WriteHeader(http.StatusPreconditionFailed)
 		return
 	}
-	log.Printf("applying patch (%s)", incomingChecksum)
+	log.Printf("applying patch (%s) to %s", incomingChecksum, root.Remote)
 	defer req.Body.Close()
-	updated, err := fsutil.ApplyPatch(srv.opts.syncDir, req.Body)
+	changes, err := fsutil.ApplyPatch(root.Remote, req.Body)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "failed to uncompress patch tar: %+v", err)
 		return
 	}
-	srv.lastUpdatedFiles = updated
+	localChanges := root.localChanges(changes)
+	srv.pendingChanges.add(localChanges, srv.opts.buildConditions)
 
-	log.Printf("patch applied, killing process")
+	action, sig := reloadFor(srv.opts.reloadRules, localChanges)
+	var sum uint64
+	if action < reloadRestart {
+		tree, err := walkRoots(srv.opts.roots)
+		if err != nil {
+			log.Printf("[warn] failed to walk sync dirs, rebuilding: %+v", err)
+			action = reloadRebuild
+		}
+		sum = fsutil.CombinedChecksum(tree)
+	}
 
 	srv.nannyLock.Lock()
-	srv.procNanny.Kill() // restart the process on next proxied request
+	srv.reload(action, sig, sum)
+	srv.stopChangedProcs(localChanges)
 	srv.nannyLock.Unlock()
 
+	newSum, _ := strconv.ParseUint(incomingChecksum, 10, 64)
+	srv.events.emit(types.Event{
+		Type:     types.EventPatchApplied,
+		Checksum: newSum,
+		Mount:    &mountIdx,
+		Changes:  len(localChanges),
+		Action:   action.String(),
+	})
+
+	if (srv.opts.eagerBuild || srv.opts.worker) && action >= reloadRestart {
+		go srv.startEagerly() // runs after the patch is over
+	}
+
 	w.WriteHeader(http.StatusAccepted)
 	log.Printf("completed patch (%s), responding with %d %s", incomingChecksum, http.StatusAccepted, http.StatusText(http.StatusAccepted))
 	return
 }
 
+// syncHandler lets the client sync without proxying a request: it responds
+// with 200 if the checksum on the request matches the sync directories, or
+// with the checksum mismatch response otherwise.
+func (srv *daemonServer) syncHandler(w http.ResponseWriter, req *http.Request) {
+	srv.patchLock.RLock()
+	defer srv.patchLock.RUnlock()
+	fs, err := walkRoots(srv.opts.roots)
+	if err != nil {
+		writeErrorResp(w, http.StatusInternalServerError, errors.Wrap(err, "failed to walk the sync directories"))
+		return
+	}
+	sum := fmt.Sprintf("%d", fsutil.CombinedChecksum(fs))
+	if req.Header.Get(constants.HdrRundevChecksum) != sum {
+		writeChecksumMismatchResp(w, fs)
+		return
+	}
+	w.Header().Set(constants.HdrRundevChecksum, sum)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (srv *daemonServer) restartHandler(w http.ResponseWriter, req *http.Request) {
 	srv.nannyLock.Lock()
 	defer srv.nannyLock.Unlock()
 
+	srv.procNanny.Kill() // clear crash loop state
 	if err := srv.procNanny.Restart(); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "error restarting process: %+v", err)
@@ -321,11 +469,39 @@ func (srv *daemonServer) killHandler(w http.ResponseWriter, req *http.Request) {
 	srv.nannyLock.Unlock()
 }
 
+// logsHandler responds with the output of the processes, grouped by process,
+// with the time, stream and incarnation of each line. The name query
+// parameter selects a single process, and the lines are filtered with the
+// since, stream, incarnation and tail parameters (see parseLogQuery).
 func (srv *daemonServer) logsHandler(w http.ResponseWriter, req *http.Request) {
-	srv.nannyLock.Lock()
-	defer srv.nannyLock.Unlock()
-	b := srv.procLogs.Bytes()
-	_, _ = w.Write(b)
+	q, err := parseLogQuery(req.URL.Query(), time.Now())
+	if err != nil {
+		writeErrorResp(w, http.StatusBadRequest, err)
+		return
+	}
+	if name := req.URL.Query().Get("name"); name == mainProcName {
+		writeLines(w, srv.procLogs.query(q))
+		return
+	} else if name != "" {
+		p := srv.findProc(name)
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "no process named %q", name)
+			return
+		}
+		writeLines(w, p.logs.query(q))
+		return
+	}
+	if len(srv.procs) == 0 {
+		writeLines(w, srv.procLogs.query(q))
+		return
+	}
+	fmt.Fprintf(w, "==> %s <==\n", mainProcName)
+	writeLines(w, srv.procLogs.query(q))
+	for _, p := range srv.procs {
+		fmt.Fprintf(w, "\n==> %s <==\n", p.Name)
+		writeLines(w, p.logs.query(q))
+	}
 }
 
 func (srv *daemonServer) psHandler(w http.ResponseWriter, req *http.Request) {
@@ -347,29 +523,109 @@ func (srv *daemonServer) psHandler(w http.ResponseWriter, req *http.Request) {
 			display(out, cid, indent+1)
 		}
 	}
-	display(w, 1, 0)
+	if len(srv.procs) == 0 {
+		display(w, 1, 0)
+		return
+	}
+	type root struct {
+		name string
+		pid  int
+	}
+	roots := []root{{mainProcName, srv.procNanny.Pid()}}
+	for _, p := range srv.procs {
+		roots = append(roots, root{p.Name, p.nanny.Pid()})
+	}
+	for _, r := range roots {
+		fmt.Fprintf(w, "==> %s <==\n", r.name)
+		if _, ok := pids.Procs[r.pid]; !ok {
+			fmt.Fprintln(w, "not running")
+			continue
+		}
+		display(w, r.pid, 0)
+	}
 }
 
+// statusHandler responds with the status of the daemon as text, or as JSON
+// (types.DaemonStatus) with ?format=json or Accept: application/json.
 func (srv *daemonServer) statusHandler(w http.ResponseWriter, req *http.Request) {
-	fs, err := fsutil.Walk(srv.opts.syncDir, srv.opts.ignores)
+	fs, err := walkRoots(srv.opts.roots)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "failed to fetch local filesystem: %+v", err)
 		return
 	}
-	fmt.Fprintf(w, "fs checksum: %v\n", fs.RootChecksum())
+	if handlerutil.WantsJSON(req) {
+		handlerutil.WriteJSON(w, srv.status(fs))
+		return
+	}
+	fmt.Fprintf(w, "fs checksum: %v\n", fsutil.CombinedChecksum(fs))
 	fmt.Fprintf(w, "pid: %d\n", os.Getpid())
 	fmt.Fprintf(w, "incarnation: %s\n", srv.incarnation)
 	wd, _ := os.Getwd()
 	fmt.Fprintf(w, "cwd: %s\n", wd)
 	fmt.Fprintf(w, "child process running: %v\n", srv.procNanny.Running())
+	if e := srv.procNanny.LastExit(); e != nil {
+		fmt.Fprintf(w, "child process last exit: %s\n", e)
+	}
+	fmt.Fprintf(w, "child process crash looping: %v\n", srv.procNanny.CrashLooping())
+	fmt.Fprintf(w, "child process port: %d\n", srv.appPort())
+	if exits := srv.procNanny.Exits(); len(exits) > 0 {
+		fmt.Fprintln(w, "child process exits:")
+		for _, e := range exits {
+			fmt.Fprintf(w, "  -> %s\n", e)
+		}
+	}
+	if len(srv.procs) > 0 {
+		fmt.Fprintln(w, "processes:")
+		for _, p := range srv.procs {
+			fmt.Fprintf(w, "  -> %s\n", p)
+		}
+	}
+	fmt.Fprintln(w, "pending changes:")
+	for _, f := range srv.pendingChanges.list() {
+		fmt.Fprintf(w, "  %s\n", f)
+	}
+	srv.startLock.Lock()
+	last, failed := srv.lastBuild, srv.swapFailure
+	srv.startLock.Unlock()
+	if failed != nil {
+		fmt.Fprintf(w, "new version failed to start: checksum=%d at=%s: %s\n", failed.sum, failed.at.Format(time.RFC3339), failed.procErr.Message)
+	}
+	if last != nil {
+		fmt.Fprintf(w, "last build: checksum=%d started=%s took=%v\n", last.sum, last.start.Format(time.RFC3339), last.took)
+		for _, s := range last.steps {
+			status := "ok"
+			if s.err != nil {
+				status = s.err.Error()
+			}
+			fmt.Fprintf(w, "  -> build cmd #%d: %s (took %v)\n", s.cmdIdx, status, s.took)
+		}
+	}
 	fmt.Fprint(w, "opts:\n")
-	fmt.Fprintf(w, "  ignores: %# v\n", pretty.Formatter(srv.opts.ignores))
+	fmt.Fprintln(w, "  sync dirs:")
+	for i, r := range srv.opts.roots {
+		fmt.Fprintf(w, "  -> %s => %s (checksum: %d, ignores: %# v)\n", r.Local, r.Remote, fs[i].RootChecksum(), pretty.Formatter(r.ignores))
+	}
+	fmt.Fprintf(w, "  work dir: %s\n", srv.opts.workDir)
 	fmt.Fprintf(w, "  port wait timeout: %# v\n", pretty.Formatter(srv.opts.portWaitTimeout))
+	if srv.opts.readyPath != "" {
+		fmt.Fprintf(w, "  readiness probe: GET %s (interval: %v)\n", srv.opts.readyPath, srv.opts.readyInterval)
+	}
+	fmt.Fprintf(w, "  stop signal: %v (grace period: %v)\n", srv.opts.stopSignal, srv.opts.stopGracePeriod)
+	fmt.Fprintf(w, "  restart policy: %s (backoff: %v)\n", srv.opts.restartPolicy, srv.opts.restartBackoff)
+	if len(srv.opts.reloadRules) > 0 {
+		fmt.Fprintln(w, "  reload rules:")
+		for _, r := range srv.opts.reloadRules {
+			fmt.Fprintf(w, "  -> %s (signal: %v): %v\n", r.action, r.signal, r.on)
+		}
+	}
+	fmt.Fprintf(w, "  blue/green: %v (ports: %d, %d)\n", srv.opts.blueGreen, srv.opts.childPort, srv.opts.altPort)
+	fmt.Fprintf(w, "  worker: %v\n", srv.opts.worker)
 	fmt.Fprintf(w, "  run-cmd: %# v\n", pretty.Formatter(srv.opts.runCmd))
 	fmt.Fprintln(w, "  build-cmds:")
 	for _, v := range srv.opts.buildCmds {
-		fmt.Fprintf(w, "  -> %s (on: %s)\n", pretty.Formatter(v.C), pretty.Formatter(v.On))
+		fmt.Fprintf(w, "  -> %s (on: %s, dir: %s, env: %v, timeout: %v)\n", pretty.Formatter(v.C), pretty.Formatter(v.On),
+			buildDir(v, srv.opts.workDir), v.Env, v.Timeout)
 	}
 }
 
@@ -392,8 +648,9 @@ func writeErrorResp(w http.ResponseWriter, code int, err error) {
 	fmt.Fprint(w, err.Error())
 }
 
-func writeChecksumMismatchResp(w http.ResponseWriter, fs fsutil.FSNode) {
-	w.Header().Set(constants.HdrRundevChecksum, fmt.Sprintf("%d", fs.RootChecksum()))
+// writeChecksumMismatchResp responds with the directory trees of each sync root, in order.
+func writeChecksumMismatchResp(w http.ResponseWriter, fs []fsutil.FSNode) {
+	w.Header().Set(constants.HdrRundevChecksum, fmt.Sprintf("%d", fsutil.CombinedChecksum(fs)))
 	w.Header().Set("Content-Type", constants.MimeChecksumMismatch)
 	w.WriteHeader(http.StatusPreconditionFailed)
 
@@ -404,18 +661,6 @@ func writeChecksumMismatchResp(w http.ResponseWriter, fs fsutil.FSNode) {
 	_, _ = io.Copy(w, &b)
 }
 
-// matches checks any of the files match any of the patterns
-func matches(files, patterns []string) bool {
-	for _, f := range files {
-		for _, p := range patterns {
-			if ok, _ := doublestar.Match(p, f); ok {
-				return true
-			}
-		}
-	}
-	return false
-}
-
 type responseRecorder struct {
 	rw         http.ResponseWriter
 	statusCode int
@@ -423,6 +668,11 @@ type responseRecorder struct {
 
 func (rr *responseRecorder) Header() http.Header         { return rr.rw.Header() }
 func (rr *responseRecorder) Write(b []byte) (int, error) { return rr.rw.Write(b) }
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
 func (rr *responseRecorder) WriteHeader(statusCode int) {
 	rr.statusCode = statusCode
 	rr.rw.WriteHeader(statusCode)
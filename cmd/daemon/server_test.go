@@ -0,0 +1,113 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestSyncHandler(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	srv := &daemonServer{opts: daemonOpts{roots: newSyncRoots(types.Mounts{{Local: ".", Remote: tmp}})}}
+	fs, err := walkRoots(srv.opts.roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := fmt.Sprintf("%d", fsutil.CombinedChecksum(fs))
+
+	tests := []struct {
+		name     string
+		checksum string
+		want     int
+	}{
+		{"in sync", sum, http.StatusOK},
+		{"out of sync", "1", http.StatusPreconditionFailed},
+		{"no checksum", "", http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/rundevd/sync", nil)
+			if tt.checksum != "" {
+				req.Header.Set(constants.HdrRundevChecksum, tt.checksum)
+			}
+			w := httptest.NewRecorder()
+			srv.syncHandler(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, expected %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get(constants.HdrRundevChecksum); got != sum {
+				t.Fatalf("got checksum header %q, expected %q", got, sum)
+			}
+		})
+	}
+}
+
+func TestPatchHandler_rejectedPatchKeepsBuild(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	srv := &daemonServer{
+		opts:   daemonOpts{roots: newSyncRoots(types.Mounts{{Local: ".", Remote: tmp}})},
+		builds: new(buildTracker),
+	}
+	fs, err := walkRoots(srv.opts.roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := fmt.Sprintf("%d", fs[0].RootChecksum())
+
+	tests := []struct {
+		name         string
+		precondition string
+		incoming     string
+		want         int
+	}{
+		{"no-op", sum, sum, http.StatusAccepted},
+		{"precondition failed", "1", "2", http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, done := srv.builds.start(fs)
+			defer done()
+
+			req := httptest.NewRequest(http.MethodPatch, "/rundevd/patch", nil)
+			req.Header.Set("content-type", constants.MimePatch)
+			req.Header.Set(constants.HdrRundevPatchPreconditionSum, tt.precondition)
+			req.Header.Set(constants.HdrRundevChecksum, tt.incoming)
+			w := httptest.NewRecorder()
+			srv.patch(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, expected %d", w.Code, tt.want)
+			}
+			if ctx.Err() != nil {
+				t.Fatal("build canceled by a patch that was not applied")
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"log"
+	"time"
+)
+
+// startOp builds and starts the user process for a tree, and waits for it to
+// listen on its port. It is shared by the requests for the same tree.
+type startOp struct {
+	sum      uint64        // combined checksum of the sync roots
+	done     chan struct{} // closed when the op is over
+	swapping chan struct{} // closed when the previous version keeps serving while a new one starts (-blue-green)
+
+	// results, set before done is closed
+	canceled bool // build was canceled by a newer patch
+	procErr  *types.ProcError
+	notReady bool             // procErr is a timeout waiting for the running process to listen
+	swapErr  *types.ProcError // new version failed to start, previous version serving (-blue-green)
+}
+
+// buildRecord is the outcome of running the build cmds for a tree.
+type buildRecord struct {
+	sum   uint64
+	start time.Time
+	took  time.Duration
+	steps []buildStep
+}
+
+type buildStep struct {
+	cmdIdx int
+	took   time.Duration
+	output []byte
+	err    error
+}
+
+// startOnce returns the in-flight start operation for the tree fs, or begins
+// a new one.
+func (srv *daemonServer) startOnce(fs []fsutil.FSNode) *startOp {
+	sum := fsutil.CombinedChecksum(fs)
+	srv.startLock.Lock()
+	defer srv.startLock.Unlock()
+	if op := srv.startOp; op != nil && op.sum == sum {
+		return op
+	}
+	op := &startOp{sum: sum, done: make(chan struct{}), swapping: make(chan struct{})}
+	srv.startOp = op
+	go func() {
+		op.canceled, op.procErr = srv.ensureRunning(fs, op.swapping)
+		if !op.canceled && op.procErr == nil && !srv.opts.worker {
+			op.procErr = srv.waitPort()
+			op.notReady = op.procErr != nil && srv.procNanny.Running()
+		}
+		if !op.canceled && op.procErr == nil {
+			op.swapErr = srv.swapFailureFor(op.sum)
+		}
+		srv.startLock.Lock()
+		if srv.startOp == op {
+			srv.startOp = nil
+		}
+		srv.startLock.Unlock()
+		close(op.done)
+	}()
+	return op
+}
+
+// finished reports whether the op is over.
+func (op *startOp) finished() bool {
+	select {
+	case <-op.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// startEagerly begins building and starting the user process for the current
+// tree without waiting for a request. Requests arriving in the meantime share
+// the same operation. Failures are logged, as no request may be waiting.
+func (srv *daemonServer) startEagerly() {
+	srv.patchLock.RLock()
+	defer srv.patchLock.RUnlock()
+	fs, err := walkRoots(srv.opts.roots)
+	if err != nil {
+		log.Printf("[warn] eager build skipped: %+v", err)
+		return
+	}
+	log.Printf("[build] starting eager build")
+	op := srv.startOnce(fs)
+	go func() {
+		<-op.done
+		if op.procErr != nil {
+			log.Printf("[warn] eager start failed: %s\n%s", op.procErr.Message, op.procErr.Output)
+		}
+	}()
+}
+
+// waitPort waits for the user process to start listening on its port. If it
+// listens on another port instead, requests are proxied to that port.
+func (srv *daemonServer) waitPort() *types.ProcError {
+	port := srv.procNanny.Port()
+	if lp := srv.appPort(); lp != port && !srv.portChecker(port).checkPort() && newTCPPortChecker(lp).checkPort() {
+		return nil // still listening on the detected port
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), srv.opts.portWaitTimeout)
+	defer cancel()
+	ready := make(chan error, 1)
+	go func() { ready <- srv.portChecker(port).waitPort(ctx) }()
+
+	tick := time.NewTicker(listenPortPollInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case err := <-ready:
+			if err == nil {
+				srv.setListenPort(0)
+				return nil
+			}
+			return srv.notListeningError(port)
+		case <-tick.C:
+			pid := srv.procNanny.Pid()
+			if pid == 0 {
+				continue
+			}
+			ports, err := listeningPorts(pid)
+			if err != nil {
+				continue // reported if the process doesn't get ready
+			}
+			if p, ok := otherListenPort(ports, port); ok {
+				log.Printf("[warn] user process listens on port %d instead of $PORT (%d), proxying requests to port %d", p, port, p)
+				srv.setListenPort(p)
+				return nil
+			}
+		}
+	}
+}
+
+// notListeningError explains why the user process is not ready after waiting
+// for it to listen on port.
+func (srv *daemonServer) notListeningError(port int) *types.ProcError {
+	msg := fmt.Sprintf("child process did not %s in %v", readyDesc(srv.opts, port), srv.opts.portWaitTimeout)
+	if pid := srv.procNanny.Pid(); pid != 0 {
+		ports, err := listeningPorts(pid)
+		if err != nil {
+			log.Printf("[warn] failed to find the ports the user process listens on: %+v", err)
+		} else {
+			msg += listenPortsHint(ports, port)
+		}
+	}
+	return &types.ProcError{
+		Message: msg,
+		Output:  srv.procLogs.String()}
+}
+
+// ensureRunning runs the build cmds and starts the user process, if it's not
+// running. With -blue-green, a running process built from an older tree is
+// swapped with a new one, and swapping is closed so requests are served by the
+// running process in the meantime. It reports whether the build was canceled
+// because a newer patch arrived for the tree (fs) it started from.
+func (srv *daemonServer) ensureRunning(fs []fsutil.FSNode, swapping chan<- struct{}) (canceled bool, procErr *types.ProcError) {
+	srv.nannyLock.Lock()
+	defer srv.nannyLock.Unlock()
+	sum := fsutil.CombinedChecksum(fs)
+	if srv.procNanny.Running() {
+		if !srv.opts.blueGreen || srv.runningSum == sum {
+			srv.startProcs()
+			return false, nil
+		}
+		close(swapping)
+		canceled := srv.swap(fs)
+		if !canceled {
+			srv.startProcs()
+		}
+		return canceled, nil
+	}
+	if srv.procNanny.CrashLooping() {
+		return false, crashLoopError(srv.procNanny.Exits())
+	}
+
+	log.Printf("[rev proxy] user process not running, restarting")
+	if srv.noBuild {
+		log.Printf("[build] skipping build cmds, changed files don't need a rebuild")
+	} else if canceled, procErr := srv.build(fs); canceled || procErr != nil {
+		return canceled, procErr
+	}
+	if err := srv.procNanny.Restart(); err != nil {
+		// TODO return structured response for errors
+		return false, &types.ProcError{
+			Message: fmt.Sprintf("failed to start child process: %+v", err),
+			Output:  srv.procLogs.String()}
+	}
+	srv.setListenPort(0) // detected again for the new process
+	srv.runningSum = sum
+	srv.noBuild = false
+	srv.setSwapFailure(nil)
+	srv.startProcs()
+	return false, nil
+}
+
+// build runs the build cmds for the tree fs, skipping the ones with
+// conditions not matching the pending changes. It must be called while
+// holding the nannyLock.
+func (srv *daemonServer) build(fs []fsutil.FSNode) (canceled bool, procErr *types.ProcError) {
+	ctx, done := srv.builds.start(fs)
+	defer done()
+	srv.buildLogs.Reset()
+	rec := &buildRecord{sum: fsutil.CombinedChecksum(fs), start: time.Now()}
+	srv.events.emit(types.Event{Type: types.EventBuildStarted, Checksum: rec.sum})
+	defer func() {
+		rec.took = time.Since(rec.start)
+		srv.startLock.Lock()
+		srv.lastBuild = rec
+		srv.startLock.Unlock()
+
+		e := types.Event{Type: types.EventBuildFinished, Checksum: rec.sum, Duration: rec.took, Canceled: canceled}
+		if procErr != nil {
+			e.Error = procErr.Message
+			e.ExitCode = cmdExitCode(rec.steps[len(rec.steps)-1].err)
+		}
+		srv.events.emit(e)
+	}()
+	gen := srv.pendingChanges.snapshot() // patches may arrive while building
+	executed := 0
+	for i, bc := range srv.opts.buildCmds {
+		log.Printf("[build] build cmd (%d of %d): %v", i, len(srv.opts.buildCmds), bc)
+		if len(bc.On) > 0 && !srv.pendingChanges.needs(i) {
+			log.Println("[build] updates files don't match, skip")
+			continue
+		}
+
+		log.Println("[build] executing build command")
+		start := time.Now()
+		b, err := runBuildCmd(ctx, bc, srv.opts.workDir, srv.buildLogs)
+		srv.buildLogs.Flush()
+		rec.steps = append(rec.steps, buildStep{cmdIdx: i, took: time.Since(start), output: b, err: err})
+		if err != nil {
+			if ctx.Err() == context.Canceled {
+				log.Printf("[build] build cmd canceled")
+				return true, nil
+			}
+			log.Printf("[build] build cmd failure: %s", string(b))
+			return false, &types.ProcError{
+				Message: fmt.Sprintf("executing -build-cmd (%v) failed: %s", bc, err),
+				Output:  string(b)}
+		}
+		srv.pendingChanges.done(i, gen)
+		executed++
+	}
+	srv.pendingChanges.reset(gen)
+	log.Printf("executed %d of %d build cmds in %v", executed, len(srv.opts.buildCmds), time.Since(rec.start))
+	return false, nil
+}
+
+// crashLoopError reports the recent exits of a crash looping user process.
+func crashLoopError(exits []procExit) *types.ProcError {
+	out := &types.ProcError{
+		Message: fmt.Sprintf("user process is crash looping (exited %d times shortly after starting), "+
+			"not restarting it until files are changed", crashLoopThreshold),
+	}
+	for _, e := range exits {
+		out.Exits = append(out.Exits, e.export())
+	}
+	if len(exits) > 0 {
+		out.Output = exits[len(exits)-1].logTail
+	}
+	return out
+}
+
+func (e procExit) export() types.ProcExit {
+	return types.ProcExit{
+		Incarnation: e.incarnation,
+		Status:      e.status,
+		ExitCode:    e.code,
+		Signal:      e.signal,
+		Uptime:      e.uptime,
+		Logs:        e.logTail,
+
+		Stopped:      e.stopped,
+		StopDuration: e.stopTook,
+		Killed:       e.killed,
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fakeNanny struct {
+	mu         sync.Mutex
+	running    bool
+	restarts   int
+	port       int
+	pid        int
+	standby    int // port of the standby process, zero if none
+	promotions int
+	signals    []syscall.Signal
+}
+
+func (f *fakeNanny) Running() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running
+}
+
+func (f *fakeNanny) Restart() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running = true
+	f.restarts++
+	return nil
+}
+
+func (f *fakeNanny) Kill() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running = false
+}
+
+func (f *fakeNanny) LastExit() *procExit { return nil }
+func (f *fakeNanny) Exits() []procExit   { return nil }
+func (f *fakeNanny) CrashLooping() bool  { return false }
+
+func (f *fakeNanny) Port() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.port
+}
+
+func (f *fakeNanny) Pid() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pid
+}
+
+func (f *fakeNanny) Signal(sig syscall.Signal) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.signals = append(f.signals, sig)
+	return nil
+}
+
+func (f *fakeNanny) StartStandby(port int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.standby = port
+	return nil
+}
+
+func (f *fakeNanny) Promote() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.port, f.standby = f.standby, 0
+	f.promotions++
+	return nil
+}
+
+func (f *fakeNanny) DiscardStandby() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.standby = 0
+}
+
+type fakePortChecker struct{}
+
+func (fakePortChecker) checkPort() bool                { return true }
+func (fakePortChecker) waitPort(context.Context) error { return nil }
+
+type closedPortChecker struct{}
+
+func (closedPortChecker) checkPort() bool { return false }
+func (closedPortChecker) waitPort(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func testStartServer(t *testing.T, buildCmd string) (*daemonServer, *fakeNanny, string) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := new(fakeNanny)
+	return &daemonServer{
+		opts: daemonOpts{
+			workDir:         tmp,
+			buildCmds:       types.BuildCmds{{C: types.Cmd{"/bin/sh", "-c", buildCmd}}},
+			portWaitTimeout: time.Second,
+		},
+		procLogs:       new(logBuffer),
+		buildLogs:      new(logBuffer),
+		pendingChanges: newPendingChanges(),
+		builds:         new(buildTracker),
+		portCheck:      fakePortChecker{},
+		procNanny:      n,
+	}, n, tmp
+}
+
+func startConcurrently(srv *daemonServer, fs []fsutil.FSNode, n int) []*startOp {
+	ops := make([]*startOp, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ops[i] = srv.startOnce(fs)
+			<-ops[i].done
+		}(i)
+	}
+	wg.Wait()
+	return ops
+}
+
+func TestStartOnce_shared(t *testing.T) {
+	srv, n, tmp := testStartServer(t, "echo x >> builds; sleep 0.2")
+	defer os.RemoveAll(tmp)
+
+	ops := startConcurrently(srv, []fsutil.FSNode{{Name: "a"}}, 20)
+	for _, op := range ops {
+		if op.canceled || op.procErr != nil {
+			t.Fatalf("start failed: canceled=%v err=%#v", op.canceled, op.procErr)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(tmp, "builds"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Count(b, []byte("x")); got != 1 {
+		t.Fatalf("build cmd ran %d times, expected once", got)
+	}
+	if n.restarts != 1 {
+		t.Fatalf("process restarted %d times, expected once", n.restarts)
+	}
+	if srv.lastBuild == nil || len(srv.lastBuild.steps) != 1 {
+		t.Fatalf("build not recorded: %#v", srv.lastBuild)
+	}
+}
+
+func TestStartOnce_sharedError(t *testing.T) {
+	srv, n, tmp := testStartServer(t, "sleep 0.2; echo failed; exit 1")
+	defer os.RemoveAll(tmp)
+
+	ops := startConcurrently(srv, []fsutil.FSNode{{Name: "a"}}, 20)
+	for _, op := range ops {
+		if op.procErr == nil {
+			t.Fatal("expected build error")
+		} else if op.procErr != ops[0].procErr {
+			t.Fatalf("requests got different errors: %#v, %#v", op.procErr, ops[0].procErr)
+		}
+	}
+	if n.restarts != 0 {
+		t.Fatal("process should not start after build failure")
+	}
+}
+
+func TestStartEagerly(t *testing.T) {
+	srv, n, tmp := testStartServer(t, "sleep 0.2")
+	defer os.RemoveAll(tmp)
+	srv.opts.roots = newSyncRoots(types.Mounts{{Local: ".", Remote: tmp}})
+
+	srv.startEagerly()
+	fs, err := walkRoots(srv.opts.roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := srv.startOnce(fs) // request arriving during the eager build
+	<-op.done
+	if op.procErr != nil {
+		t.Fatal(op.procErr)
+	}
+	if n.restarts != 1 {
+		t.Fatalf("process restarted %d times, expected once", n.restarts)
+	}
+}
+
+func TestStartOnce_workerSkipsPortWait(t *testing.T) {
+	srv, n, tmp := testStartServer(t, "true")
+	defer os.RemoveAll(tmp)
+	srv.portCheck = closedPortChecker{}
+	srv.opts.worker = true
+
+	op := srv.startOnce([]fsutil.FSNode{{Name: "a"}})
+	<-op.done
+	if op.procErr != nil {
+		t.Fatal(op.procErr)
+	}
+	if n.restarts != 1 {
+		t.Fatalf("process restarted %d times, expected once", n.restarts)
+	}
+}
+
+func TestWaitPort_otherPortDetectedEarly(t *testing.T) {
+	li, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+	if _, err := listeningPorts(os.Getpid()); err != nil {
+		t.Skipf("procfs not available: %v", err)
+	}
+	srv, n, tmp := testStartServer(t, "true")
+	defer os.RemoveAll(tmp)
+	srv.portCheck = closedPortChecker{}
+	srv.opts.portWaitTimeout = time.Second * 10
+	n.port, n.pid = 5555, os.Getpid()
+
+	start := time.Now()
+	if err := srv.waitPort(); err != nil {
+		t.Fatal(err.Message)
+	}
+	if d := time.Since(start); d > time.Second*2 {
+		t.Fatalf("detecting the port took %v", d)
+	}
+	if got, want := srv.appPort(), li.Addr().(*net.TCPAddr).Port; got != want {
+		t.Fatalf("appPort()=%d, want=%d", got, want)
+	}
+
+	// a new process doesn't inherit the port detected for the previous one
+	n.running, n.pid = false, 0
+	srv.opts.portWaitTimeout = time.Millisecond * 100
+	op := srv.startOnce([]fsutil.FSNode{{Name: "a"}})
+	<-op.done
+	if op.procErr == nil || srv.appPort() != 5555 {
+		t.Fatalf("expected the new process to be waited on $PORT, got err=%v port=%d", op.procErr, srv.appPort())
+	}
+}
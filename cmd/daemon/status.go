@@ -0,0 +1,126 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/signals"
+	"github.com/ahmetb/rundev/lib/types"
+	"os"
+	"runtime"
+)
+
+// status returns the status of the daemon served by /rundevd/debugz as JSON,
+// where fs are the trees of the sync directories.
+func (srv *daemonServer) status(fs []fsutil.FSNode) types.DaemonStatus {
+	wd, _ := os.Getwd()
+	out := types.DaemonStatus{
+		Version:        constants.Version,
+		GoVersion:      runtime.Version(),
+		Pid:            os.Getpid(),
+		Incarnation:    srv.incarnation,
+		Cwd:            wd,
+		Checksum:       fsutil.CombinedChecksum(fs),
+		Process:        processStatus(mainProcName, srv.procNanny),
+		PendingChanges: []string{},
+		Options:        srv.optionsStatus(),
+	}
+	out.Process.Port = srv.appPort()
+	for i, r := range srv.opts.roots {
+		out.Mounts = append(out.Mounts, types.MountStatus{
+			Local:    r.Local,
+			Remote:   r.Remote,
+			Checksum: fs[i].RootChecksum(),
+			Ignores:  r.Ignores,
+		})
+	}
+	for _, p := range srv.procs {
+		out.Processes = append(out.Processes, processStatus(p.Name, p.nanny))
+	}
+	for _, c := range srv.pendingChanges.list() {
+		out.PendingChanges = append(out.PendingChanges, c.String())
+	}
+
+	srv.startLock.Lock()
+	last, failed := srv.lastBuild, srv.swapFailure
+	srv.startLock.Unlock()
+	if last != nil {
+		b := &types.BuildStatus{Checksum: last.sum, Started: last.start, Duration: last.took, Steps: []types.BuildStep{}}
+		for _, s := range last.steps {
+			step := types.BuildStep{Cmd: s.cmdIdx, Duration: s.took}
+			if s.err != nil {
+				step.Error = s.err.Error()
+			}
+			b.Steps = append(b.Steps, step)
+		}
+		out.LastBuild = b
+	}
+	if failed != nil {
+		out.SwapFailure = &types.SwapFailure{Checksum: failed.sum, Time: failed.at, Error: failed.procErr.Message}
+	}
+	return out
+}
+
+func processStatus(name string, n nanny) types.ProcessStatus {
+	out := types.ProcessStatus{
+		Name:         name,
+		Running:      n.Running(),
+		Pid:          n.Pid(),
+		Port:         n.Port(),
+		CrashLooping: n.CrashLooping(),
+	}
+	for _, e := range n.Exits() {
+		out.Exits = append(out.Exits, e.export())
+	}
+	if e := n.LastExit(); e != nil {
+		x := e.export()
+		out.LastExit = &x
+	}
+	return out
+}
+
+func (srv *daemonServer) optionsStatus() types.DaemonOptions {
+	out := types.DaemonOptions{
+		WorkDir:         srv.opts.workDir,
+		RunCmd:          srv.opts.runCmd,
+		BuildCmds:       srv.opts.buildCmds,
+		Port:            srv.opts.childPort,
+		PortWaitTimeout: srv.opts.portWaitTimeout,
+		ReadyPath:       srv.opts.readyPath,
+		ReadyInterval:   srv.opts.readyInterval,
+		EagerBuild:      srv.opts.eagerBuild,
+		Worker:          srv.opts.worker,
+		BlueGreen:       srv.opts.blueGreen,
+		StopSignal:      signals.Name(srv.opts.stopSignal),
+		StopGracePeriod: srv.opts.stopGracePeriod,
+		RestartPolicy:   string(srv.opts.restartPolicy),
+		RestartBackoff:  srv.opts.restartBackoff,
+	}
+	if srv.opts.blueGreen {
+		out.AltPort = srv.opts.altPort
+	}
+	for _, p := range srv.opts.procs {
+		out.Processes = append(out.Processes, p.Process)
+	}
+	for _, r := range srv.opts.reloadRules {
+		rule := types.ReloadRule{On: r.on, Action: r.action.String()}
+		if r.action == reloadSignal {
+			rule.Signal = signals.Name(r.signal)
+		}
+		out.ReloadRules = append(out.ReloadRules, rule)
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStatusHandler(t *testing.T) {
+	srv, _, tmp := testStartServer(t, "exit 1")
+	defer os.RemoveAll(tmp)
+	srv.incarnation = "abc"
+	srv.opts.roots = newSyncRoots(types.Mounts{{Local: ".", Remote: tmp, Ignores: []string{"*.log"}}})
+	srv.opts.stopSignal = syscall.SIGTERM
+	srv.opts.reloadRules = []reloadRule{{on: []string{"*.conf"}, action: reloadSignal, signal: syscall.SIGHUP}}
+	if _, procErr := srv.build([]fsutil.FSNode{{Name: "a"}}); procErr == nil {
+		t.Fatal("expected build error")
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		json   bool
+	}{
+		{name: "text by default", accept: "text/html,*/*"},
+		{name: "format=json", query: "?format=json", json: true},
+		{name: "accept json", accept: "application/json", json: true},
+		{name: "format=text overrides accept", query: "?format=text", accept: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/rundevd/debugz"+tt.query, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			srv.statusHandler(w, req)
+			if !tt.json {
+				if !strings.HasPrefix(w.Body.String(), "fs checksum: ") {
+					t.Fatalf("expected text response, got:\n%s", w.Body.String())
+				}
+				return
+			}
+			var st types.DaemonStatus
+			if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+				t.Fatal(err)
+			}
+			if st.Version != constants.Version || st.Incarnation != "abc" || st.Pid != os.Getpid() {
+				t.Fatalf("unexpected status: %+v", st)
+			}
+			if len(st.Mounts) != 1 || st.Mounts[0].Remote != tmp || st.Mounts[0].Checksum == 0 {
+				t.Fatalf("unexpected mounts: %+v", st.Mounts)
+			}
+			if st.Process.Name != mainProcName || st.Process.Running {
+				t.Fatalf("unexpected process status: %+v", st.Process)
+			}
+			if b := st.LastBuild; b == nil || len(b.Steps) != 1 || b.Steps[0].Error != "exit status 1" {
+				t.Fatalf("unexpected last build: %+v", b)
+			}
+			if o := st.Options; o.StopSignal != "SIGTERM" || len(o.ReloadRules) != 1 || o.ReloadRules[0].Signal != "SIGHUP" {
+				t.Fatalf("unexpected options: %+v", o)
+			}
+		})
+	}
+}
+
+func TestProcessStatus_lastExit(t *testing.T) {
+	n := newProcessNanny("/bin/true", nil, procOpts{}).(*procNanny)
+	n.lastExit = &procExit{incarnation: 2, status: "signal: killed", code: -1, signal: "killed",
+		stopped: true, stopTook: time.Second * 5, killed: true}
+
+	st := processStatus(mainProcName, n)
+	expected := &types.ProcExit{Incarnation: 2, Status: "signal: killed", ExitCode: -1, Signal: "killed",
+		Stopped: true, StopDuration: time.Second * 5, Killed: true}
+	if diff := cmp.Diff(expected, st.LastExit); diff != "" {
+		t.Fatal(diff)
+	}
+}
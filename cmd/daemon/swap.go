@@ -0,0 +1,176 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/ahmetb/rundev/lib/constants"
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/types"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// swapFailure is a new version of the user process that failed to build or
+// start with -blue-green, while the previous version kept serving.
+type swapFailure struct {
+	sum     uint64
+	at      time.Time
+	procErr *types.ProcError
+}
+
+// portChecker returns the port checker for the port the user process
+// listens on.
+func (srv *daemonServer) portChecker(port int) portChecker {
+	if srv.altPortCheck != nil && port == srv.opts.altPort {
+		return srv.altPortCheck
+	}
+	return srv.portCheck
+}
+
+// otherPort returns the port to start the next version of the user process
+// on, while the current one listens on port.
+func (srv *daemonServer) otherPort(port int) int {
+	if port == srv.opts.altPort {
+		return srv.opts.childPort
+	}
+	return srv.opts.altPort
+}
+
+func (srv *daemonServer) swapFailureFor(sum uint64) *types.ProcError {
+	srv.startLock.Lock()
+	defer srv.startLock.Unlock()
+	if f := srv.swapFailure; f != nil && f.sum == sum {
+		return f.procErr
+	}
+	return nil
+}
+
+func (srv *daemonServer) setSwapFailure(f *swapFailure) {
+	srv.startLock.Lock()
+	srv.swapFailure = f
+	srv.startLock.Unlock()
+}
+
+// swap builds the tree fs and starts the new version on the other port while
+// the current process keeps serving, then switches over to it. If the new
+// version fails, the current process keeps serving and the failure is
+// recorded for the tree, so it's not retried until a newer patch arrives. It
+// must be called while holding the nannyLock.
+func (srv *daemonServer) swap(fs []fsutil.FSNode) (canceled bool) {
+	sum := fsutil.CombinedChecksum(fs)
+	if srv.swapFailureFor(sum) != nil {
+		return false
+	}
+	log.Printf("[swap] building new version, previous version keeps serving")
+	canceled, procErr := srv.build(fs)
+	if canceled {
+		return true
+	}
+	if procErr == nil {
+		procErr = srv.startStandby()
+	}
+	if procErr != nil {
+		log.Printf("[swap] new version failed, previous version keeps serving: %s", procErr.Message)
+		srv.setSwapFailure(&swapFailure{sum: sum, at: time.Now(), procErr: procErr})
+		return false
+	}
+	srv.runningSum = sum
+	srv.setSwapFailure(nil)
+	return false
+}
+
+// startStandby starts the new version on the other port, and promotes it once
+// it's listening.
+func (srv *daemonServer) startStandby() *types.ProcError {
+	port := srv.otherPort(srv.procNanny.Port())
+	log.Printf("[swap] starting new version on port %d", port)
+	if err := srv.procNanny.StartStandby(port); err != nil {
+		return &types.ProcError{
+			Message: fmt.Sprintf("failed to start child process: %+v", err),
+			Output:  srv.procLogs.String()}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), srv.opts.portWaitTimeout)
+	defer cancel()
+	if err := srv.portChecker(port).waitPort(ctx); err != nil {
+		srv.procNanny.DiscardStandby()
+		return &types.ProcError{
+			Message: fmt.Sprintf("new version did not %s in %v", readyDesc(srv.opts, port), srv.opts.portWaitTimeout),
+			Output:  srv.procLogs.String()}
+	}
+	if err := srv.procNanny.Promote(); err != nil {
+		return &types.ProcError{Message: fmt.Sprintf("failed to switch to the new version: %+v", err)}
+	}
+	srv.setListenPort(0) // the new version listens on $PORT
+	log.Printf("[swap] switched to new version on port %d", port)
+	return nil
+}
+
+var bodyTag = regexp.MustCompile(`(?i)<body[^>]*>`)
+
+// writeWithSwapBanner copies the response of the previous version of the
+// user process, with the error of the new version in a header and, for
+// HTML pages, in a banner at the top of the page.
+func writeWithSwapBanner(w http.ResponseWriter, resp *http.Response, swapErr *types.ProcError) {
+	for k, vals := range resp.Header {
+		for _, v := range vals {
+			w.Header().Add(k, v)
+		}
+	}
+	w.Header().Set(constants.HdrRundevSwapError, strings.SplitN(swapErr.Message, "\n", 2)[0])
+	if !strings.HasPrefix(resp.Header.Get("content-type"), "text/html") || resp.Header.Get("content-encoding") != "" {
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("[warn] failed to read response body: %+v", err)
+	}
+	b = injectBanner(b, swapBanner(swapErr))
+	w.Header().Set("content-length", strconv.Itoa(len(b)))
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(b)
+}
+
+// injectBanner inserts the banner after the <body> tag of the page, or at the
+// beginning if there's none.
+func injectBanner(page []byte, banner string) []byte {
+	i := 0
+	if loc := bodyTag.FindIndex(page); loc != nil {
+		i = loc[1]
+	}
+	var out bytes.Buffer
+	out.Grow(len(page) + len(banner))
+	out.Write(page[:i])
+	out.WriteString(banner)
+	out.Write(page[i:])
+	return out.Bytes()
+}
+
+func swapBanner(pe *types.ProcError) string {
+	return `<div style="background:#c62828;color:#fff;font:14px monospace;padding:8px 12px;white-space:pre-wrap">` +
+		`rundev: new version failed to start, showing the previous version.` + "\n" +
+		html.EscapeString(pe.Message) + `</div>`
+}
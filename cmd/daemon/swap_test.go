@@ -0,0 +1,128 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInjectBanner(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"body tag", "<html><body class=x>hi</body></html>", "<html><body class=x>BANNERhi</body></html>"},
+		{"upper case", "<HTML><BODY>hi</BODY></HTML>", "<HTML><BODY>BANNERhi</BODY></HTML>"},
+		{"no body tag", "<p>hi</p>", "BANNER<p>hi</p>"},
+		{"empty", "", "BANNER"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(injectBanner([]byte(tt.page), "BANNER"))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("injectBanner() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func testSwapServer(t *testing.T, buildCmd string) (*daemonServer, *fakeNanny, string) {
+	srv, n, tmp := testStartServer(t, buildCmd)
+	srv.opts.blueGreen = true
+	srv.opts.childPort, srv.opts.altPort = 5555, 5556
+	srv.altPortCheck = fakePortChecker{}
+	n.port = 5555
+	op := srv.startOnce(testTree(1))
+	<-op.done
+	if op.procErr != nil {
+		t.Fatal(op.procErr)
+	}
+	return srv, n, tmp
+}
+
+// testTree returns a tree with a distinct checksum for each version.
+func testTree(version int64) []fsutil.FSNode {
+	return []fsutil.FSNode{{Name: "a", Nodes: []fsutil.FSNode{{Name: "f", Size: version}}}}
+}
+
+func TestSwap(t *testing.T) {
+	srv, n, tmp := testSwapServer(t, "true")
+	defer os.RemoveAll(tmp)
+
+	op := srv.startOnce(testTree(2))
+	<-op.done
+	if op.procErr != nil || op.swapErr != nil {
+		t.Fatalf("swap failed: %#v %#v", op.procErr, op.swapErr)
+	}
+	if n.promotions != 1 || n.port != 5556 {
+		t.Fatalf("expected new version on port 5556, got port=%d promotions=%d", n.port, n.promotions)
+	}
+	if n.restarts != 1 {
+		t.Fatalf("process restarted %d times, expected once", n.restarts)
+	}
+}
+
+func TestSwap_failureKeepsServing(t *testing.T) {
+	srv, n, tmp := testSwapServer(t, `test ! -f fail || exit 1`)
+	defer os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "fail"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		op := srv.startOnce(testTree(2))
+		<-op.done
+		if op.procErr != nil {
+			t.Fatalf("request should be served by the previous version: %#v", op.procErr)
+		}
+		if op.swapErr == nil {
+			t.Fatal("expected swap error")
+		}
+	}
+	if !n.Running() || n.port != 5555 || n.promotions != 0 {
+		t.Fatalf("previous version should keep serving, got running=%v port=%d promotions=%d", n.Running(), n.port, n.promotions)
+	}
+	if len(srv.lastBuild.steps) != 1 {
+		t.Fatal("failed build should not be retried for the same tree")
+	}
+}
+
+func TestSwap_previousVersionServesWhileStarting(t *testing.T) {
+	srv, n, tmp := testSwapServer(t, `test ! -f slow || sleep 1`)
+	defer os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "slow"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	op := srv.startOnce(testTree(2))
+	select {
+	case <-op.swapping:
+	case <-op.done:
+		t.Fatal("op finished before the swap started")
+	}
+	if op.finished() || !n.Running() || srv.appPort() != 5555 {
+		t.Fatalf("previous version should serve during the swap, got finished=%v running=%v port=%d", op.finished(), n.Running(), srv.appPort())
+	}
+	<-op.done
+	if op.procErr != nil || n.port != 5556 {
+		t.Fatalf("swap failed: %#v port=%d", op.procErr, n.port)
+	}
+}
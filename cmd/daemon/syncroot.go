@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ahmetb/rundev/lib/fsutil"
+	"github.com/ahmetb/rundev/lib/handlerutil"
+	"github.com/ahmetb/rundev/lib/ignore"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/pkg/errors"
+	"path"
+)
+
+// syncRoot is a directory in the container synced from the client.
+type syncRoot struct {
+	types.Mount
+	ignores *ignore.FileIgnores
+}
+
+func newSyncRoots(mounts types.Mounts) []syncRoot {
+	out := make([]syncRoot, 0, len(mounts))
+	for _, m := range mounts {
+		out = append(out, syncRoot{
+			Mount:   m,
+			ignores: ignore.NewFileIgnores(m.Ignores).WithBase(m.IgnoreBase),
+		})
+	}
+	return out
+}
+
+// walkRoots walks the directory trees of the sync roots, in order.
+func walkRoots(roots []syncRoot) ([]fsutil.FSNode, error) {
+	out := make([]fsutil.FSNode, 0, len(roots))
+	for _, r := range roots {
+		fs, err := fsutil.Walk(r.Remote, r.ignores)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to walk sync dir %s", r.Remote)
+		}
+		out = append(out, fs)
+	}
+	return out, nil
+}
+
+// localChanges converts the paths relative to the root to paths relative to
+// the build context on the client, which build conditions are written against.
+func (r syncRoot) localChanges(changes []fsutil.Change) []fsutil.Change {
+	out := make([]fsutil.Change, 0, len(changes))
+	for _, c := range changes {
+		out = append(out, fsutil.Change{Path: path.Join(r.Local, c.Path), Type: c.Type})
+	}
+	return out
+}
+
+func fsDebugRoots(roots []syncRoot) []handlerutil.FSRoot {
+	var out []handlerutil.FSRoot
+	for _, r := range roots {
+		out = append(out, handlerutil.FSRoot{Name: r.Local, Dir: r.Remote, Ignores: r.ignores})
+	}
+	return out
+}
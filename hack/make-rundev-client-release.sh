@@ -20,10 +20,15 @@ SCRIPTDIR="$( cd "$( dirname "${BASH_SOURCE[0]}" )" && pwd )"
 cd "${SCRIPTDIR}/.."
 
 bucket="${BUCKET:-rundev-test}"
+version="${VERSION:-v0.0.0-$(git describe --always --dirty)}"
 subpath="${SUBPATH:-nightly/client}"
 file="rundev-$(date -u +%Y-%m-%d-%H%M%S)-$(git describe --always --dirty)"
 file_latest="rundev-latest"
 
+# the client deploys the rundevd release of the same version, publish it first
+echo >&2 "uploading rundevd ${version}"
+VERSION="${version}" BUCKET="${bucket}" "${SCRIPTDIR}/upload-rundevd-release.sh" >&2
+
 build_dir="$(mktemp -d)"
 trap 'rm -rf -- "${build_dir}"' EXIT
 
@@ -32,7 +37,8 @@ for os in darwin linux; do
   fp="${bucket}/${subpath}/${os}/${file}"
   fp_latest="${bucket}/${subpath}/${os}/${file_latest}"
 
-  GOOS="${os}" GOARCH="amd64" go build -o "${build_dir}/out" ./cmd/client
+  GOOS="${os}" GOARCH="amd64" go build \
+    -ldflags "-X github.com/ahmetb/rundev/lib/constants.Version=${version}" -o "${build_dir}/out" ./cmd/client
   echo >&2 "uploading ${os}"
 	gsutil -q cp "${build_dir}/out" gs://"${fp}" 1>&2
 	gsutil -q cp "${build_dir}/out" gs://"${fp_latest}" 1>&2
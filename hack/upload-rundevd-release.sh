@@ -19,11 +19,15 @@ SCRIPTDIR="$( cd "$( dirname "${BASH_SOURCE[0]}" )" && pwd )"
 
 cd "${SCRIPTDIR}/.."
 
-name="rundevd-v0.0.0-$(git describe --always --dirty)"
+# the client built by make-rundev-client-release.sh with the same VERSION
+# deploys this binary
+version="${VERSION:-v0.0.0-$(git describe --always --dirty)}"
+name="rundevd-${version}"
 bucket="${BUCKET:-rundev-test}"
 
 env CGO_ENABLED=0 GOOS=linux GOARCH=amd64 \
-	go build -o /dev/stdout ./cmd/daemon \
+	go build -ldflags "-X github.com/ahmetb/rundev/lib/constants.Version=${version}" \
+		-o /dev/stdout ./cmd/daemon \
 		| gsutil -q cp - gs://"${bucket}/${name}" 1>&2
 
 echo "https://storage.googleapis.com/${bucket}/${name}"
@@ -18,11 +18,16 @@ const (
 	HdrRundevChecksum             = `rundev-checksum`
 	HdrRundevPatchPreconditionSum = `rundev-apply-if-checksum`
 	HdrRundevClientSecret         = `rundev-client-secret`
+	HdrRundevMount                = `rundev-mount`
+	HdrRundevSwapError            = `rundev-swap-error`
+	HdrRundevIncarnation          = `rundev-incarnation`
 
 	MimeDumbRepeat       = `application/vnd.rundev.repeat`
 	MimeChecksumMismatch = `application/vnd.rundev.checksumMismatch+json`
 	MimePatch            = `application/vnd.rundev.patch+tar`
 	MimeProcessError     = `application/vnd.rundev.procError+json`
+	MimeLogStream        = `application/vnd.rundev.logStream+json`
+	MimeEventStream      = `application/vnd.rundev.eventStream+json`
 
 	WhiteoutDeleteSuffix = ".whiteout.del"
 )
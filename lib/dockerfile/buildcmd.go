@@ -2,52 +2,77 @@ package dockerfile
 
 import (
 	"github.com/ahmetb/rundev/lib/types"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/pkg/errors"
 	"regexp"
 	"strings"
-	"unicode"
+	"time"
 )
 
 var (
-	runCmdAnnotationPattern = regexp.MustCompile(`\s+#\s?rundev(\[(.*)\])?$`)
+	runCmdAnnotationPattern = regexp.MustCompile(`\s+#\s?rundev(\[(.*)\])?((?:\s+[a-z]+=\S*)*)\s*$`)
 )
 
 // ParseBuildCmds extracts RUN commands from the last dockerfile stage with #rundev or #rundev[PATTERN,..] annotation.
-func ParseBuildCmds(d *Dockerfile) types.BuildCmds {
+// The annotation can be followed by cwd=DIR, env=KEY=VALUE (repeatable) and timeout=DURATION options.
+func ParseBuildCmds(d *Dockerfile) (types.BuildCmds, error) {
 	var out types.BuildCmds
-	for _, stmt := range d.Stmts() {
+	var err error
+	d.walk(func(stmt *parser.Node, s *stage) {
 		switch stmt.Value {
 		case "from":
 			out = nil // reset
 		case "run":
-			if !runCmdAnnotationPattern.MatchString(stmt.Original) {
-				continue
+			if err != nil || !runCmdAnnotationPattern.MatchString(stmt.Original) {
+				return
 			}
 
 			var conditions []string
 			parts := runCmdAnnotationPattern.FindStringSubmatch(stmt.Original)
-			if len(parts) > 1 {
-				condStr := parts[len(parts)-1]
-				conds := strings.Split(condStr, ",")
-				if condStr != "" && len(conds) > 0 {
-					conditions = conds
-				}
+			if condStr := parts[2]; condStr != "" {
+				conditions = strings.Split(condStr, ",")
 			}
 
-			c := parseCommand(stmt.Next, stmt.Attributes["json"])
-			cm := Cmd{c[0], c[1:]}
-
-			// trim comment at the end of argv (as dockerfile parser isn't doing so)
-			if len(cm.Args) > 0 {
-				v := cm.Args[len(cm.Args)-1]
-				v = runCmdAnnotationPattern.ReplaceAllString(v, "")
-				v = strings.TrimRightFunc(v, unicode.IsSpace)
-				cm.Args[len(cm.Args)-1] = v
-			}
-			out = append(out, types.BuildCmd{
+			json := stmt.Attributes["json"]
+			c := trimAnnotation(d.parseCommand(stmt.Next, json, s), json, runCmdAnnotationPattern)
+			cm := Cmd{Cmd: c[0], Args: c[1:]}
+			bc := types.BuildCmd{
 				C:  cm.Flatten(),
 				On: conditions,
-			})
+			}
+			if e := parseBuildOpts(&bc, parts[3]); e != nil {
+				err = errors.Wrapf(e, "failed to parse #rundev annotation of %q", stmt.Original)
+				return
+			}
+			out = append(out, bc)
+		}
+	})
+	return out, err
+}
+
+// parseBuildOpts parses the whitespace-separated KEY=VALUE options of a build
+// cmd annotation into bc.
+func parseBuildOpts(bc *types.BuildCmd, opts string) error {
+	for _, f := range strings.Fields(opts) {
+		kv := strings.SplitN(f, "=", 2)
+		k, v := kv[0], kv[1]
+		switch k {
+		case "cwd":
+			bc.Dir = v
+		case "env":
+			if !strings.Contains(v, "=") {
+				return errors.Errorf("env option %q is not in KEY=VALUE format", v)
+			}
+			bc.Env = append(bc.Env, v)
+		case "timeout":
+			t, err := time.ParseDuration(v)
+			if err != nil {
+				return errors.Wrapf(err, "invalid timeout %q", v)
+			}
+			bc.Timeout = t
+		default:
+			return errors.Errorf("unknown option %q", k)
 		}
 	}
-	return out
+	return nil
 }
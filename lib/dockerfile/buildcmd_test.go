@@ -4,13 +4,16 @@ import (
 	"github.com/ahmetb/rundev/lib/types"
 	"github.com/google/go-cmp/cmp"
 	"testing"
+	"time"
 )
 
 func TestParseBuildCmds(t *testing.T) {
 	tests := []struct {
-		name string
-		df   string
-		want types.BuildCmds
+		name      string
+		df        string
+		buildArgs map[string]string
+		want      types.BuildCmds
+		wantErr   bool
 	}{
 		{
 			name: "no run cmds",
@@ -88,6 +91,135 @@ RUN apt-get -qqy install \
 			df:   `RUN ["date"] # rundev [foo]`,
 			want: nil,
 		},
+		{
+			name: "run cmd with options",
+			df:   `RUN go build -o /out/server . # rundev[**/*.go] cwd=cmd/server env=CGO_ENABLED=0 env=GOFLAGS=-mod=vendor timeout=2m`,
+			want: []types.BuildCmd{
+				{
+					C:       []string{"/bin/sh", "-c", "go build -o /out/server ."},
+					On:      []string{"**/*.go"},
+					Dir:     "cmd/server",
+					Env:     []string{"CGO_ENABLED=0", "GOFLAGS=-mod=vendor"},
+					Timeout: 2 * time.Minute,
+				},
+			},
+		},
+		{
+			name: "run cmd with options without conditions",
+			df:   `RUN ["make"] # rundev timeout=30s`,
+			want: []types.BuildCmd{{C: []string{"make"}, Timeout: 30 * time.Second}},
+		},
+		{
+			name:    "unknown option",
+			df:      `RUN make # rundev foo=bar`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid timeout",
+			df:      `RUN make # rundev timeout=soon`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid env",
+			df:      `RUN make # rundev env=FOO`,
+			wantErr: true,
+		},
+		{
+			name: "run annotation is not a build cmd",
+			df:   `RUN date # rundev-run: date`,
+			want: nil,
+		},
+		{
+			name: "custom shell",
+			df: `FROM alpine
+SHELL ["/bin/ash", "-eo", "pipefail", "-c"]
+RUN apk add foo # rundev`,
+			want: []types.BuildCmd{
+				{C: []string{"/bin/ash", "-eo", "pipefail", "-c", "apk add foo"}},
+			},
+		},
+		{
+			name: "shell is reset in new stage",
+			df: `FROM foo
+SHELL ["powershell", "-command"]
+FROM bar
+RUN make # rundev`,
+			want: []types.BuildCmd{
+				{C: []string{"/bin/sh", "-c", "make"}},
+			},
+		},
+		{
+			name: "shell and env inherited from parent stage",
+			df: `FROM foo AS base
+SHELL ["pwsh", "-c"]
+ENV OUT=C:\\out
+FROM base
+RUN dotnet build -o $OUT # rundev`,
+			want: []types.BuildCmd{
+				{C: []string{"pwsh", "-c", `dotnet build -o C:\out`}},
+			},
+		},
+		{
+			name: "arg and env expanded",
+			df: `FROM golang
+ARG VERSION=1.0
+ENV OUT=/out CGO_ENABLED=0
+RUN go build -ldflags "-X main.version=${VERSION}" -o $OUT/server . # rundev`,
+			want: []types.BuildCmd{
+				{C: []string{"/bin/sh", "-c", `go build -ldflags "-X main.version=1.0" -o /out/server .`}},
+			},
+		},
+		{
+			name: "unknown, escaped and single-quoted vars are not expanded",
+			df: `FROM golang
+ENV A=x
+RUN echo $PORT \$A '$A' "$A" ${B:-y} ${A:+z} # rundev`,
+			want: []types.BuildCmd{
+				{C: []string{"/bin/sh", "-c", `echo $PORT \$A '$A' "x" ${B:-y} z`}},
+			},
+		},
+		{
+			name: "exec form is not expanded",
+			df: `FROM golang
+ENV OUT=/out
+RUN ["go", "build", "-o", "$OUT"] # rundev`,
+			want: []types.BuildCmd{
+				{C: []string{"go", "build", "-o", "$OUT"}},
+			},
+		},
+		{
+			name: "env overrides arg",
+			df: `FROM golang
+ARG V=arg
+ENV V=env
+RUN echo $V # rundev`,
+			want: []types.BuildCmd{
+				{C: []string{"/bin/sh", "-c", "echo env"}},
+			},
+		},
+		{
+			name: "build arg overrides",
+			df: `ARG GLOBAL=g1
+ARG OTHER=o1
+FROM golang
+ARG GLOBAL
+ARG LOCAL=l1
+RUN echo $GLOBAL $LOCAL $OTHER # rundev`,
+			buildArgs: map[string]string{"GLOBAL": "g2", "LOCAL": "l2"},
+			want: []types.BuildCmd{
+				{C: []string{"/bin/sh", "-c", "echo g2 l2 $OTHER"}},
+			},
+		},
+		{
+			name: "args are not visible in new stage",
+			df: `FROM foo
+ARG V=1
+FROM bar
+RUN echo $V # rundev`,
+			want: []types.BuildCmd{
+				{C: []string{"/bin/sh", "-c", "echo $V"}},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -95,7 +227,11 @@ RUN apt-get -qqy install \
 			if err != nil {
 				t.Fatalf("parsing dockerfile failed: %v", err)
 			}
-			got := ParseBuildCmds(df)
+			df.SetBuildArgs(tt.buildArgs)
+			got, err := ParseBuildCmds(df)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBuildCmds() error = %v, wantErr %v", err, tt.wantErr)
+			}
 
 			if diff := cmp.Diff(tt.want, got); diff != "" {
 				t.Errorf("ParseBuildCmds() returned unexpected results:\n%s", diff)
@@ -0,0 +1,76 @@
+package dockerfile
+
+import (
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"path"
+	"strings"
+)
+
+// PathMapping is a path in the build context that is copied into the image
+// with a COPY or ADD instruction.
+type PathMapping struct {
+	Local  string // relative to the build context, slash-separated
+	Remote string // destination in the image, relative if the WORKDIR is not known
+	ToDir  bool   // destination is a directory (ends with a slash or has multiple sources)
+}
+
+// Target returns the path in the image the source is copied to. Contents of
+// directories are copied into the destination, whereas files are copied
+// into it only if the destination is a directory.
+func (m PathMapping) Target(srcIsDir bool) string {
+	if srcIsDir || !m.ToDir {
+		return m.Remote
+	}
+	return path.Join(m.Remote, path.Base(m.Local))
+}
+
+// ParseWorkdir returns the WORKDIR of the last stage, or an empty string if
+// it's not set in the dockerfile.
+func ParseWorkdir(d *Dockerfile) string {
+	var out string
+	d.walk(func(stmt *parser.Node, s *stage) { out = s.workdir })
+	return out
+}
+
+// ParseCopyMappings extracts the paths copied from the build context with
+// COPY and ADD instructions in the last dockerfile stage. Copies from other
+// stages (--from) and remote ADD sources are not included.
+func ParseCopyMappings(d *Dockerfile) []PathMapping {
+	var out []PathMapping
+	d.walk(func(stmt *parser.Node, s *stage) {
+		switch stmt.Value {
+		case "from":
+			out = nil // reset
+		case "copy", "add":
+			for _, f := range stmt.Flags {
+				if strings.HasPrefix(f, "--from=") {
+					return
+				}
+			}
+			var args []string
+			for n := stmt.Next; n != nil; n = n.Next {
+				args = append(args, d.processWord(n.Value, s.vars()))
+			}
+			if len(args) < 2 {
+				return
+			}
+			srcs, dst := args[:len(args)-1], args[len(args)-1]
+			toDir := strings.HasSuffix(dst, "/") || len(srcs) > 1
+			for _, src := range srcs {
+				if strings.Contains(src, "://") {
+					continue
+				}
+				local := strings.TrimPrefix(path.Clean("/"+src), "/")
+				if local == "" {
+					local = "."
+				}
+				out = append(out, PathMapping{
+					Local:  local,
+					Remote: s.resolve(dst),
+					ToDir:  toDir,
+				})
+			}
+		}
+	})
+	return out
+}
@@ -0,0 +1,105 @@
+package dockerfile
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestParseCopyMappings(t *testing.T) {
+	tests := []struct {
+		name string
+		df   string
+		want []PathMapping
+	}{
+		{
+			name: "no copies",
+			df:   "FROM scratch",
+			want: nil,
+		},
+		{
+			name: "copy everything to workdir",
+			df: `FROM python
+WORKDIR /app
+COPY . .`,
+			want: []PathMapping{{Local: ".", Remote: "/app"}},
+		},
+		{
+			name: "copy without workdir",
+			df: `FROM golang
+COPY ./ src/`,
+			want: []PathMapping{{Local: ".", Remote: "src", ToDir: true}},
+		},
+		{
+			name: "relative workdirs",
+			df: `FROM node
+WORKDIR /app
+WORKDIR src
+COPY lib lib`,
+			want: []PathMapping{{Local: "lib", Remote: "/app/src/lib"}},
+		},
+		{
+			name: "subdirectories and files",
+			df: `FROM python
+WORKDIR /app
+COPY requirements.txt setup.py ./
+ADD ["src/", "/app/src"]`,
+			want: []PathMapping{
+				{Local: "requirements.txt", Remote: "/app", ToDir: true},
+				{Local: "setup.py", Remote: "/app", ToDir: true},
+				{Local: "src", Remote: "/app/src"},
+			},
+		},
+		{
+			name: "variables expanded",
+			df: `FROM python
+ENV APP_HOME=/app
+WORKDIR $APP_HOME
+COPY . ${APP_HOME}/`,
+			want: []PathMapping{{Local: ".", Remote: "/app", ToDir: true}},
+		},
+		{
+			name: "copies from other stages and urls excluded",
+			df: `FROM golang AS build
+COPY . /src
+FROM alpine
+COPY --from=build /src/server /server
+ADD https://example.com/foo.tgz /foo
+COPY --chown=app:app config/ /etc/app/`,
+			want: []PathMapping{{Local: "config", Remote: "/etc/app", ToDir: true}},
+		},
+		{
+			name: "workdir inherited from parent stage",
+			df: `FROM node AS base
+WORKDIR /app
+FROM base
+COPY . .`,
+			want: []PathMapping{{Local: ".", Remote: "/app"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			df, err := ParseDockerfile([]byte(tt.df))
+			if err != nil {
+				t.Fatalf("parsing dockerfile failed: %v", err)
+			}
+			got := ParseCopyMappings(df)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseCopyMappings() returned unexpected results:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPathMapping_Target(t *testing.T) {
+	m := PathMapping{Local: "src/app.py", Remote: "/app", ToDir: true}
+	if got, want := m.Target(false), "/app/app.py"; got != want {
+		t.Errorf("file into dir: got=%q want=%q", got, want)
+	}
+	if got, want := m.Target(true), "/app"; got != want {
+		t.Errorf("dir into dir: got=%q want=%q", got, want)
+	}
+	m.ToDir = false
+	if got, want := m.Target(false), "/app"; got != want {
+		t.Errorf("file to file: got=%q want=%q", got, want)
+	}
+}
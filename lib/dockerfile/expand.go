@@ -0,0 +1,114 @@
+package dockerfile
+
+import (
+	"strings"
+)
+
+// expandVars substitutes $NAME, ${NAME}, ${NAME:-word} and ${NAME:+word}
+// references in a shell-form command with the values in vars.
+//
+// Unlike docker's word processing, quotes are preserved as the result is
+// still passed to a shell. References in single quotes, escaped references
+// and references to unknown variables (such as $PORT, provided at runtime)
+// are left untouched for the shell to handle.
+func expandVars(s string, vars map[string]string, escape rune) string {
+	if escape == 0 {
+		escape = '\\'
+	}
+	var out strings.Builder
+	rs := []rune(s)
+	inSingle, inDouble := false, false
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case inSingle:
+			if c == '\'' {
+				inSingle = false
+			}
+			out.WriteRune(c)
+		case c == escape && i+1 < len(rs):
+			out.WriteRune(c)
+			out.WriteRune(rs[i+1])
+			i++
+		case c == '\'' && !inDouble:
+			inSingle = true
+			out.WriteRune(c)
+		case c == '"':
+			inDouble = !inDouble
+			out.WriteRune(c)
+		case c == '$':
+			v, n := expandRef(rs[i:], vars, escape)
+			out.WriteString(v)
+			i += n - 1
+		default:
+			out.WriteRune(c)
+		}
+	}
+	return out.String()
+}
+
+// expandRef expands the variable reference at the beginning of rs, and
+// returns the expanded value along with the number of runes consumed.
+func expandRef(rs []rune, vars map[string]string, escape rune) (string, int) {
+	if len(rs) > 1 && rs[1] != '{' {
+		n := 1
+		for n < len(rs) && isNameRune(rs[n], n == 1) {
+			n++
+		}
+		if n == 1 {
+			return "$", 1
+		}
+		if v, ok := vars[string(rs[1:n])]; ok {
+			return v, n
+		}
+		return string(rs[:n]), n
+	}
+
+	// ${...} form
+	end := -1
+	depth := 0
+	for j := 1; j < len(rs); j++ {
+		if rs[j] == '{' {
+			depth++
+		} else if rs[j] == '}' {
+			depth--
+			if depth == 0 {
+				end = j
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return string(rs), len(rs) // unterminated, leave it to the shell
+	}
+	body := string(rs[2:end])
+	name, modifier, word := body, "", ""
+	if i := strings.Index(body, ":"); i >= 0 && i+1 < len(body) {
+		name, modifier, word = body[:i], body[i:i+2], body[i+2:]
+	}
+	v, ok := vars[name]
+	if !ok {
+		return string(rs[:end+1]), end + 1
+	}
+	switch modifier {
+	case ":-":
+		if v == "" {
+			v = expandVars(word, vars, escape)
+		}
+	case ":+":
+		if v != "" {
+			v = expandVars(word, vars, escape)
+		}
+	case "":
+	default:
+		return string(rs[:end+1]), end + 1 // unsupported modifier
+	}
+	return v, end + 1
+}
+
+func isNameRune(r rune, first bool) bool {
+	if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+		return true
+	}
+	return !first && r >= '0' && r <= '9'
+}
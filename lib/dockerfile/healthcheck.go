@@ -0,0 +1,62 @@
+package dockerfile
+
+import (
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"regexp"
+	"strings"
+)
+
+var localURLPattern = regexp.MustCompile(`https?://(?:localhost|127\.0\.0\.1|0\.0\.0\.0|\[::1\])(?::([^/\s'"]*))?(/[^\s'"]*)?`)
+
+// Healthcheck is an HTTP readiness probe derived from a HEALTHCHECK
+// instruction requesting a URL on localhost, such as:
+//
+//	HEALTHCHECK --interval=1s CMD curl -f http://localhost:$PORT/healthz
+//
+// Only the path is used: the timing options of HEALTHCHECK are meant for
+// monitoring a running container, not for waiting on it to start.
+type Healthcheck struct {
+	Path string
+	Port string // as written in the URL (e.g. "$PORT"), empty if not specified
+}
+
+// OnPortEnv checks if the URL is requested on $PORT, the only port the
+// readiness probe is sent to.
+func (h *Healthcheck) OnPortEnv() bool {
+	return h.Port == "$PORT" || h.Port == "${PORT}"
+}
+
+// ParseHealthcheck returns the readiness probe from the HEALTHCHECK of the
+// last stage. It returns nil if there's no HEALTHCHECK, it's disabled, or its
+// command doesn't request a local URL.
+func ParseHealthcheck(d *Dockerfile) *Healthcheck {
+	var out *Healthcheck
+	d.walk(func(stmt *parser.Node, s *stage) {
+		switch stmt.Value {
+		case "from":
+			out = nil // reset
+		case "healthcheck":
+			out = parseHealthcheck(stmt)
+		}
+	})
+	return out
+}
+
+func parseHealthcheck(stmt *parser.Node) *Healthcheck {
+	if stmt.Next == nil || !strings.EqualFold(stmt.Next.Value, "cmd") {
+		return nil // HEALTHCHECK NONE
+	}
+	var args []string
+	for n := stmt.Next.Next; n != nil; n = n.Next {
+		args = append(args, n.Value)
+	}
+	m := localURLPattern.FindStringSubmatch(strings.Join(args, " "))
+	if m == nil {
+		return nil
+	}
+	out := &Healthcheck{Port: m[1], Path: m[2]}
+	if out.Path == "" {
+		out.Path = "/"
+	}
+	return out
+}
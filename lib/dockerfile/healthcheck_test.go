@@ -0,0 +1,100 @@
+package dockerfile
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestParseHealthcheck(t *testing.T) {
+	tests := []struct {
+		name string
+		df   string
+		want *Healthcheck
+	}{
+		{
+			name: "not set",
+			df:   `FROM scratch`,
+		},
+		{
+			name: "disabled",
+			df: `FROM scratch
+HEALTHCHECK NONE`,
+		},
+		{
+			name: "not an http check",
+			df: `FROM scratch
+HEALTHCHECK CMD pg_isready`,
+		},
+		{
+			name: "remote url",
+			df: `FROM scratch
+HEALTHCHECK CMD curl -f https://example.com/healthz`,
+		},
+		{
+			name: "shell form",
+			df: `FROM scratch
+HEALTHCHECK --interval=2s --timeout=1s CMD curl -f http://localhost:$PORT/healthz || exit 1`,
+			want: &Healthcheck{Path: "/healthz", Port: "$PORT"},
+		},
+		{
+			name: "json form",
+			df: `FROM scratch
+HEALTHCHECK --start-period=1m CMD ["wget", "-q", "-O-", "http://127.0.0.1:8080/ready?full=1"]`,
+			want: &Healthcheck{Path: "/ready?full=1", Port: "8080"},
+		},
+		{
+			name: "no path",
+			df: `FROM scratch
+HEALTHCHECK CMD curl -f http://localhost:${PORT}`,
+			want: &Healthcheck{Path: "/", Port: "${PORT}"},
+		},
+		{
+			name: "hardcoded port",
+			df: `FROM scratch
+HEALTHCHECK CMD curl -f http://localhost:9000/healthz`,
+			want: &Healthcheck{Path: "/healthz", Port: "9000"},
+		},
+		{
+			name: "no port",
+			df: `FROM scratch
+HEALTHCHECK CMD curl -f http://localhost/healthz`,
+			want: &Healthcheck{Path: "/healthz"},
+		},
+		{
+			name: "reset in new stage",
+			df: `FROM foo
+HEALTHCHECK CMD curl -f http://localhost/healthz
+FROM bar`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			df, err := ParseDockerfile([]byte(tt.df))
+			if err != nil {
+				t.Fatalf("parsing dockerfile failed: %v", err)
+			}
+			got := ParseHealthcheck(df)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseHealthcheck() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHealthcheck_OnPortEnv(t *testing.T) {
+	tests := []struct {
+		port string
+		want bool
+	}{
+		{"$PORT", true},
+		{"${PORT}", true},
+		{"9000", false},
+		{"8080", false},
+		{"", false}, // port 80
+	}
+	for _, tt := range tests {
+		if got := (&Healthcheck{Path: "/", Port: tt.port}).OnPortEnv(); got != tt.want {
+			t.Errorf("OnPortEnv() for port %q = %v, want %v", tt.port, got, tt.want)
+		}
+	}
+}
@@ -2,17 +2,31 @@ package dockerfile
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
 	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	devCmdAnnotationPattern = regexp.MustCompile(`\s+#\s?rundev-run:(.*)$`)
 )
 
 type Dockerfile struct {
-	syntaxTree *parser.Node
+	syntaxTree  *parser.Node
+	escapeToken rune
+	buildArgs   map[string]string
 }
 
 func (d *Dockerfile) Stmts() []*parser.Node { return d.syntaxTree.Children }
 
+// SetBuildArgs overrides the values of ARG instructions, similar to
+// "docker build --build-arg".
+func (d *Dockerfile) SetBuildArgs(args map[string]string) { d.buildArgs = args }
+
 type Cmd struct {
 	Cmd  string
 	Args []string
@@ -41,24 +55,44 @@ func ParseDockerfile(b []byte) (*Dockerfile, error) {
 	if r.AST == nil {
 		return nil, errors.Wrap(err, "ast was nil")
 	}
-	return &Dockerfile{r.AST}, nil
+	return &Dockerfile{syntaxTree: r.AST, escapeToken: r.EscapeToken}, nil
 }
 
-func ParseEntrypoint(d *Dockerfile) (Cmd, error) {
+// ParseEntrypoint returns the command the image runs, merged from ENTRYPOINT and CMD values in the last stage.
+func ParseEntrypoint(d *Dockerfile) (Cmd, error) { return parseEntrypoint(d, false) }
+
+// ParseDevEntrypoint is like ParseEntrypoint, except the ENTRYPOINT or CMD values annotated with a
+// "# rundev-run: VALUE" comment are replaced with VALUE (in JSON or shell form) for development.
+func ParseDevEntrypoint(d *Dockerfile) (Cmd, error) { return parseEntrypoint(d, true) }
+
+func parseEntrypoint(d *Dockerfile, dev bool) (Cmd, error) {
 	var c Cmd
 	var epVals, cmdVals []string
-	for _, stmt := range d.Stmts() {
+	d.walk(func(stmt *parser.Node, s *stage) {
+		var v []string
 		switch stmt.Value {
 		case "from":
 			// reset (new stage)
 			epVals = nil
 			cmdVals = nil
-		case "entrypoint":
-			epVals = parseCommand(stmt.Next, stmt.Attributes["json"])
-		case "cmd":
-			cmdVals = parseCommand(stmt.Next, stmt.Attributes["json"])
+			return
+		case "entrypoint", "cmd":
+			isJSON := stmt.Attributes["json"]
+			v = trimAnnotation(d.parseCommand(stmt.Next, isJSON, s), isJSON, devCmdAnnotationPattern)
+			if dev {
+				if parts := devCmdAnnotationPattern.FindStringSubmatch(stmt.Original); len(parts) > 1 {
+					v = d.parseAnnotatedCommand(parts[1], s)
+				}
+			}
+		default:
+			return
 		}
-	}
+		if stmt.Value == "entrypoint" {
+			epVals = v
+		} else {
+			cmdVals = v
+		}
+	})
 	if len(epVals) == 0 && len(cmdVals) == 0 {
 		return c, errors.New("no CMD or ENTRYPOINT values in dockerfile")
 	}
@@ -70,13 +104,56 @@ func ParseEntrypoint(d *Dockerfile) (Cmd, error) {
 	return Cmd{epVals[0], append(epVals[1:], cmdVals...)}, nil
 }
 
-// parseCommand parses CMD and ENTRYPOINT nodes, based on whether they're JSON lists or not.
-// Non-JSON values are wrapped in [/bin/sh -c VALUE]
-func parseCommand(n *parser.Node, json bool) []string {
+// ParseStopSignal returns the STOPSIGNAL value of the last stage, or an empty string if it's not set.
+func ParseStopSignal(d *Dockerfile) string {
+	var out string
+	d.walk(func(stmt *parser.Node, s *stage) {
+		switch stmt.Value {
+		case "from":
+			out = "" // reset
+		case "stopsignal":
+			if stmt.Next != nil {
+				out = d.processWord(stmt.Next.Value, s.vars())
+			}
+		}
+	})
+	return out
+}
+
+// parseAnnotatedCommand parses the command in a rundev annotation, based on whether it's a JSON list or not,
+// in the same way parseCommand does.
+func (d *Dockerfile) parseAnnotatedCommand(v string, s *stage) []string {
+	v = strings.TrimSpace(v)
+	var out []string
+	if strings.HasPrefix(v, "[") {
+		if err := json.Unmarshal([]byte(v), &out); err == nil {
+			return out
+		}
+	}
+	return append(append(out, s.shell...), expandVars(v, s.vars(), d.escapeToken))
+}
+
+// trimAnnotation removes the annotation comment at the end of non-JSON command values (as dockerfile parser
+// isn't doing so).
+func trimAnnotation(c []string, json bool, pattern *regexp.Regexp) []string {
+	if json || len(c) == 0 {
+		return c
+	}
+	v := c[len(c)-1]
+	v = pattern.ReplaceAllString(v, "")
+	v = strings.TrimRightFunc(v, unicode.IsSpace)
+	c[len(c)-1] = v
+	return c
+}
+
+// parseCommand parses RUN, CMD and ENTRYPOINT nodes, based on whether they're JSON lists or not.
+// Non-JSON values have the ARG/ENV values of the stage expanded and are wrapped in the stage's
+// SHELL (by default, [/bin/sh -c VALUE]). Like docker, JSON values are not expanded.
+func (d *Dockerfile) parseCommand(n *parser.Node, json bool, s *stage) []string {
 	var out []string
 	for n != nil {
 		if !json {
-			return []string{"/bin/sh", "-c", n.Value}
+			return append(append(out, s.shell...), expandVars(n.Value, s.vars(), d.escapeToken))
 		}
 		out = append(out, n.Value)
 		n = n.Next
@@ -95,6 +95,46 @@ FROM a2 as c2
 ENTRYPOINT /bin/server`,
 			want: Cmd{"/bin/sh", []string{"-c", "/bin/server"}},
 		},
+		{
+			name: "custom shell",
+			df: `SHELL ["/bin/bash", "-c"]
+CMD exec /bin/server`,
+			want: Cmd{"/bin/bash", []string{"-c", "exec /bin/server"}},
+		},
+		{
+			name: "custom shell after cmd does not apply",
+			df: `CMD /bin/server
+SHELL ["/bin/bash", "-c"]`,
+			want: Cmd{"/bin/sh", []string{"-c", "/bin/server"}},
+		},
+		{
+			name: "env and arg expanded (non-json)",
+			df: `FROM python
+ARG WORKERS=2
+ENV APP=main:app
+CMD gunicorn -w ${WORKERS} -b :$PORT $APP`,
+			want: Cmd{"/bin/sh", []string{"-c", "gunicorn -w 2 -b :$PORT main:app"}},
+		},
+		{
+			name: "env not expanded (json)",
+			df: `FROM python
+ENV APP=main:app
+CMD ["gunicorn", "$APP"]`,
+			want: Cmd{"gunicorn", []string{"$APP"}},
+		},
+		{
+			name: "dev run annotation ignored",
+			df:   `CMD gunicorn app:app # rundev-run: flask run`,
+			want: Cmd{"/bin/sh", []string{"-c", "gunicorn app:app"}},
+		},
+		{
+			name: "windows escape token",
+			df: "# escape=`\n" + `FROM mcr.microsoft.com/windows/servercore
+SHELL ["powershell", "-command"]
+ENV DIR=C:\app
+ENTRYPOINT & $DIR\server.exe`,
+			want: Cmd{"powershell", []string{"-command", `& C:\app\server.exe`}},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -113,3 +153,134 @@ ENTRYPOINT /bin/server`,
 		})
 	}
 }
+
+func TestParseDevEntrypoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		df      string
+		want    Cmd
+		wantErr bool
+	}{
+		{
+			name:    "nothing",
+			df:      `FROM scratch`,
+			wantErr: true,
+		},
+		{
+			name: "not annotated",
+			df:   `CMD ["gunicorn", "app:app"]`,
+			want: Cmd{"gunicorn", []string{"app:app"}},
+		},
+		{
+			name: "cmd annotated (json)",
+			df:   `CMD ["gunicorn", "app:app"] # rundev-run: ["flask", "run", "--reload"]`,
+			want: Cmd{"flask", []string{"run", "--reload"}},
+		},
+		{
+			name: "cmd annotated (non-json)",
+			df:   `CMD ["node", "dist/index.js"] #rundev-run: ts-node src/index.ts`,
+			want: Cmd{"/bin/sh", []string{"-c", "ts-node src/index.ts"}},
+		},
+		{
+			name: "annotated non-json cmd",
+			df:   `CMD gunicorn app:app # rundev-run: ["flask", "run"]`,
+			want: Cmd{"flask", []string{"run"}},
+		},
+		{
+			name: "annotation without space before it is ignored",
+			df:   `CMD ["gunicorn", "app:app"]# rundev-run: ["flask", "run"]`,
+			want: Cmd{"gunicorn", []string{"app:app"}},
+		},
+		{
+			name: "entrypoint kept, cmd annotated",
+			df: `ENTRYPOINT ["/docker-entrypoint.sh"]
+CMD ["node", "dist/index.js"] # rundev-run: ["ts-node", "src/index.ts"]`,
+			want: Cmd{"/docker-entrypoint.sh", []string{"ts-node", "src/index.ts"}},
+		},
+		{
+			name: "entrypoint annotated",
+			df: `ENTRYPOINT ["java", "-jar", "app.jar"] # rundev-run: ["mvn", "spring-boot:run"]
+CMD ["--verbose"]`,
+			want: Cmd{"mvn", []string{"spring-boot:run", "--verbose"}},
+		},
+		{
+			name: "annotation uses shell, arg and env of the stage",
+			df: `FROM python
+SHELL ["/bin/bash", "-c"]
+ARG APP=app
+ENV FLASK_APP=${APP}.py
+CMD gunicorn $APP:app # rundev-run: flask run --port=$PORT --reload $FLASK_APP`,
+			want: Cmd{"/bin/bash", []string{"-c", "flask run --port=$PORT --reload app.py"}},
+		},
+		{
+			name: "annotation in earlier stage is reset",
+			df: `FROM python AS dev
+CMD ["a"] # rundev-run: ["b"]
+FROM python
+CMD ["c"]`,
+			want: Cmd{"c", []string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			df, err := ParseDockerfile([]byte(tt.df))
+			if err != nil {
+				t.Fatalf("parsing dockerfile failed: %v", err)
+			}
+			got, err := ParseDevEntrypoint(df)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseDevEntrypoint() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDevEntrypoint() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStopSignal(t *testing.T) {
+	tests := []struct {
+		name string
+		df   string
+		want string
+	}{
+		{
+			name: "not set",
+			df:   `FROM scratch`,
+			want: "",
+		},
+		{
+			name: "last value wins",
+			df: `FROM scratch
+STOPSIGNAL SIGTERM
+STOPSIGNAL SIGQUIT`,
+			want: "SIGQUIT",
+		},
+		{
+			name: "reset in new stage",
+			df: `FROM foo
+STOPSIGNAL SIGINT
+FROM bar`,
+			want: "",
+		},
+		{
+			name: "expanded",
+			df: `FROM foo
+ARG SIG=SIGUSR1
+STOPSIGNAL $SIG`,
+			want: "SIGUSR1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			df, err := ParseDockerfile([]byte(tt.df))
+			if err != nil {
+				t.Fatalf("parsing dockerfile failed: %v", err)
+			}
+			if got := ParseStopSignal(df); got != tt.want {
+				t.Errorf("ParseStopSignal() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
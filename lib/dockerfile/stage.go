@@ -0,0 +1,138 @@
+package dockerfile
+
+import (
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+	"path"
+	"strings"
+)
+
+var defaultShell = []string{"/bin/sh", "-c"}
+
+// stage holds the SHELL, WORKDIR, ARG and ENV state of a build stage which
+// affects how the instructions in it are interpreted.
+type stage struct {
+	shell   []string
+	workdir string // empty if not set (inherited from the base image)
+	args    map[string]string
+	env     map[string]string
+}
+
+func newStage() *stage {
+	return &stage{
+		shell: defaultShell,
+		args:  make(map[string]string),
+		env:   make(map[string]string),
+	}
+}
+
+// vars returns the variables available to the commands in the stage. ENV
+// values take precedence over ARG values with the same name.
+func (s *stage) vars() map[string]string {
+	out := make(map[string]string, len(s.args)+len(s.env))
+	for k, v := range s.args {
+		out[k] = v
+	}
+	for k, v := range s.env {
+		out[k] = v
+	}
+	return out
+}
+
+// resolve returns the path p relative to the WORKDIR of the stage. If the
+// WORKDIR is not known, relative paths are returned as is.
+func (s *stage) resolve(p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(s.workdir, p)
+}
+
+// walk calls fn for each statement in the Dockerfile along with the state of
+// the stage the statement belongs to, after the statement is evaluated.
+//
+// Stages built FROM an earlier named stage inherit its SHELL, WORKDIR and ENV
+// values. ARGs declared before the first FROM are only visible to the stages
+// that re-declare them.
+func (d *Dockerfile) walk(fn func(stmt *parser.Node, s *stage)) {
+	globalArgs := make(map[string]string)
+	named := make(map[string]*stage)
+	cur, inStage := newStage(), false
+
+	for _, stmt := range d.Stmts() {
+		switch stmt.Value {
+		case "from":
+			next := newStage()
+			if stmt.Next != nil {
+				base := d.processWord(stmt.Next.Value, globalArgs)
+				if parent, ok := named[strings.ToLower(base)]; ok {
+					next.shell = parent.shell
+					next.workdir = parent.workdir
+					for k, v := range parent.env {
+						next.env[k] = v
+					}
+				}
+				if as := stmt.Next.Next; as != nil && strings.EqualFold(as.Value, "as") && as.Next != nil {
+					named[strings.ToLower(as.Next.Value)] = next
+				}
+			}
+			cur, inStage = next, true
+		case "arg":
+			for n := stmt.Next; n != nil; n = n.Next {
+				kv := strings.SplitN(n.Value, "=", 2)
+				name := kv[0]
+				v, ok := d.buildArgs[name]
+				if !ok && len(kv) == 2 {
+					if inStage {
+						v, ok = d.processWord(kv[1], cur.vars()), true
+					} else {
+						v, ok = d.processWord(kv[1], globalArgs), true
+					}
+				}
+				if !inStage {
+					if ok {
+						globalArgs[name] = v
+					}
+					continue
+				}
+				if !ok {
+					v, ok = globalArgs[name]
+				}
+				if ok {
+					cur.args[name] = v
+				}
+			}
+		case "env":
+			for n := stmt.Next; n != nil && n.Next != nil; n = n.Next.Next {
+				cur.env[n.Value] = d.processWord(n.Next.Value, cur.vars())
+			}
+		case "workdir":
+			if stmt.Next != nil {
+				cur.workdir = cur.resolve(d.processWord(stmt.Next.Value, cur.vars()))
+			}
+		case "shell":
+			var sh []string
+			for n := stmt.Next; n != nil; n = n.Next {
+				sh = append(sh, n.Value)
+			}
+			if stmt.Attributes["json"] && len(sh) > 0 {
+				cur.shell = sh
+			}
+		}
+		fn(stmt, cur)
+	}
+}
+
+// processWord evaluates quotes and variables in an ARG or ENV value, the way
+// docker does. If the value cannot be processed, it is returned as is.
+func (d *Dockerfile) processWord(v string, vars map[string]string) string {
+	escape := d.escapeToken
+	if escape == 0 {
+		escape = '\\'
+	}
+	out, err := shell.NewLex(escape).ProcessWordWithMap(v, vars)
+	if err != nil {
+		return v
+	}
+	return out
+}
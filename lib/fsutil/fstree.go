@@ -46,6 +46,18 @@ func (f FSNode) RootChecksum() uint64 {
 	return f.childrenChecksum()
 }
 
+// CombinedChecksum computes a single checksum from the root checksums of
+// multiple directory trees, in the given order.
+func CombinedChecksum(roots []FSNode) uint64 {
+	h := fnv.New64()
+	b := make([]byte, 8)
+	for _, r := range roots {
+		binary.LittleEndian.PutUint64(b, r.RootChecksum())
+		h.Write(b)
+	}
+	return h.Sum64()
+}
+
 // checksum computes the checksum of f based on f itself and its children.
 func (f FSNode) checksum() uint64 {
 	h := fnv.New64()
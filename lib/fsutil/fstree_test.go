@@ -115,3 +115,18 @@ func TestChecksumRoot(t *testing.T) {
 		t.Fatal("nodes was supposed to trigger root checksum change")
 	}
 }
+
+func TestCombinedChecksum(t *testing.T) {
+	a := FSNode{Nodes: []FSNode{{Name: "a"}}}
+	b := FSNode{Nodes: []FSNode{{Name: "b"}}}
+
+	if CombinedChecksum([]FSNode{a, b}) != CombinedChecksum([]FSNode{a, b}) {
+		t.Fatal("combined checksums are not idempotent")
+	}
+	if CombinedChecksum([]FSNode{a, b}) == CombinedChecksum([]FSNode{b, a}) {
+		t.Fatal("order of roots was supposed to change the combined checksum")
+	}
+	if CombinedChecksum([]FSNode{a}) == CombinedChecksum([]FSNode{a, a}) {
+		t.Fatal("number of roots was supposed to change the combined checksum")
+	}
+}
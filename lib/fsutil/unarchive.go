@@ -21,12 +21,59 @@ import (
 	"github.com/pkg/errors"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
-func ApplyPatch(dir string, r io.ReadCloser) ([]string, error) {
-	var out []string
+// ChangeType is the type of change made to a file by a patch.
+type ChangeType int
+
+const (
+	ChangeAdded ChangeType = iota
+	ChangeModified
+	ChangeDeleted
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a file (slash-separated path relative to patched directory)
+// changed by a patch. Directories are not included.
+type Change struct {
+	Path string
+	Type ChangeType
+}
+
+func (c Change) String() string {
+	var s string
+	switch c.Type {
+	case ChangeAdded:
+		s = "A"
+	case ChangeModified:
+		s = "M"
+	case ChangeDeleted:
+		s = "D"
+	default:
+		s = "?"
+	}
+	return s + " " + c.Path
+}
+
+// ApplyPatch extracts the patch tarball into dir, and returns the files
+// changed by it. Files in deleted directories are reported individually.
+func ApplyPatch(dir string, r io.ReadCloser) ([]Change, error) {
+	var out []Change
 	gr, err := gzip.NewReader(r)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to initialize gzip reader")
@@ -41,7 +88,6 @@ func ApplyPatch(dir string, r io.ReadCloser) ([]string, error) {
 		}
 
 		fn := hdr.Name
-		out = append(out, fn)
 		fpath := filepath.Join(dir, filepath.FromSlash(fn))
 
 		if hdr.Typeflag == tar.TypeDir {
@@ -54,12 +100,25 @@ func ApplyPatch(dir string, r io.ReadCloser) ([]string, error) {
 		}
 
 		if strings.HasSuffix(fn, constants.WhiteoutDeleteSuffix) {
-			if err := os.RemoveAll(strings.TrimSuffix(fpath, constants.WhiteoutDeleteSuffix)); err != nil {
+			delPath := strings.TrimSuffix(fpath, constants.WhiteoutDeleteSuffix)
+			deleted, err := listFiles(dir, delPath)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to list files deleted by whiteout file %s", fn)
+			}
+			if err := os.RemoveAll(delPath); err != nil {
 				return nil, errors.Wrapf(err, "failed to realize delete whiteout file %s", fn)
 			}
+			for _, f := range deleted {
+				out = append(out, Change{Path: f, Type: ChangeDeleted})
+			}
 			continue
 		}
 
+		change := Change{Path: path.Clean(fn), Type: ChangeAdded}
+		if _, err := os.Lstat(fpath); err == nil {
+			change.Type = ChangeModified
+		}
+
 		// copy regular file
 		f, err := os.OpenFile(fpath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, hdr.FileInfo().Mode())
 		if err != nil {
@@ -77,6 +136,31 @@ func ApplyPatch(dir string, r io.ReadCloser) ([]string, error) {
 		if err := os.Chtimes(fpath, hdr.ModTime, hdr.ModTime); err != nil {
 			return nil, errors.Wrapf(err, "failed to change times of copied file for tar entry %s", fn)
 		}
+		out = append(out, change)
 	}
 	return out, nil
 }
+
+// listFiles returns the slash-separated paths of the non-directory files
+// under p (or p itself, if it's a file) relative to dir.
+func listFiles(dir, p string) ([]string, error) {
+	var out []string
+	err := filepath.Walk(p, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, fp)
+		if err != nil {
+			return err
+		}
+		out = append(out, filepath.ToSlash(rel))
+		return nil
+	})
+	return out, err
+}
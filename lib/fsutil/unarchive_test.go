@@ -0,0 +1,82 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsutil
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestApplyPatch_changes(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	write := func(dir, f, content string) {
+		fp := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(src, "modified.go", "new")
+	write(src, "added/new.go", "new")
+	write(dst, "modified.go", "old")
+	write(dst, "deleted.go", "old")
+	write(dst, "deleted-dir/a.go", "old")
+	write(dst, "deleted-dir/nested/b.go", "old")
+
+	ops := []DiffOp{
+		{Type: DiffOpAdd, Path: "added"},
+		{Type: DiffOpDel, Path: "deleted-dir"},
+		{Type: DiffOpDel, Path: "deleted.go"},
+		{Type: DiffOpAdd, Path: "modified.go"},
+	}
+	tar, _, err := PatchArchive(src, ops, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ApplyPatch(dst, ioutil.NopCloser(tar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Path < got[j].Path })
+	expected := []Change{
+		{Path: "added/new.go", Type: ChangeAdded},
+		{Path: "deleted-dir/a.go", Type: ChangeDeleted},
+		{Path: "deleted-dir/nested/b.go", Type: ChangeDeleted},
+		{Path: "deleted.go", Type: ChangeDeleted},
+		{Path: "modified.go", Type: ChangeModified},
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "deleted-dir")); !os.IsNotExist(err) {
+		t.Fatalf("deleted dir still exists: %v", err)
+	}
+}
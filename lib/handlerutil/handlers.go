@@ -25,26 +25,50 @@ import (
 	"github.com/ahmetb/rundev/lib/ignore"
 	"io"
 	"net/http"
+	"strings"
 )
 
-func NewFSDebugHandler(dir string, ignores *ignore.FileIgnores) http.HandlerFunc {
+// FSRoot is a synced directory tree displayed by the fs debug handler.
+type FSRoot struct {
+	Name    string
+	Dir     string
+	Ignores *ignore.FileIgnores
+}
+
+type fsRootResp struct {
+	Name     string        `json:"name"`
+	Dir      string        `json:"dir"`
+	Checksum uint64        `json:"checksum"`
+	FS       fsutil.FSNode `json:"fs"`
+}
+
+// NewFSDebugHandler returns a handler that displays the trees of the given
+// directories along with their checksums.
+func NewFSDebugHandler(roots []FSRoot) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		i := ignores
-		if _, ok := req.URL.Query()["full"] ; ok{
-			i = nil // ?full disables the file exclusion rules
-		}
+		_, full := req.URL.Query()["full"] // ?full disables the file exclusion rules
 
-		fs, err := fsutil.Walk(dir, i)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w,"failed to fetch local filesystem: %+v", err)
-			return
+		var resp []fsRootResp
+		var nodes []fsutil.FSNode
+		for _, r := range roots {
+			i := r.Ignores
+			if full {
+				i = nil
+			}
+			fs, err := fsutil.Walk(r.Dir, i)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "failed to fetch local filesystem: %+v", err)
+				return
+			}
+			nodes = append(nodes, fs)
+			resp = append(resp, fsRootResp{Name: r.Name, Dir: r.Dir, Checksum: fs.RootChecksum(), FS: fs})
 		}
-		w.Header().Set(constants.HdrRundevChecksum, fmt.Sprintf("%v", fs.RootChecksum()))
+		w.Header().Set(constants.HdrRundevChecksum, fmt.Sprintf("%v", fsutil.CombinedChecksum(nodes)))
 		var b bytes.Buffer
 		enc := json.NewEncoder(&b)
 		enc.SetIndent("", "  ")
-		if err := enc.Encode(fs); err != nil {
+		if err := enc.Encode(resp); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w,"failed to encode json: %+v", err)
 			return
@@ -62,3 +86,30 @@ func NewUnsupportedDebugEndpointHandler() http.HandlerFunc {
 		fmt.Fprintf(w, "not found: debug endpoint %s does not exist.", req.URL.Path)
 	}
 }
+
+// WantsJSON reports whether a debug endpoint should respond with JSON rather
+// than text, requested with ?format=json or an Accept header with
+// application/json.
+func WantsJSON(req *http.Request) bool {
+	switch req.URL.Query().Get("format") {
+	case "json":
+		return true
+	case "text":
+		return false
+	}
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+// WriteJSON responds with v encoded as indented JSON.
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "failed to encode json: %+v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.Copy(w, &b)
+}
@@ -22,6 +22,7 @@ import (
 
 type FileIgnores struct {
 	patterns []string
+	base     string
 }
 
 // Ignored tests if given relative path is excluded. If FileIgnores is nil,
@@ -30,11 +31,20 @@ func (f *FileIgnores) Ignored(path string) bool {
 	if f == nil {
 		return false
 	}
-	return ignored(path, f.patterns)
+	return ignored(filepath.Join(f.base, path), f.patterns)
 }
 
 func NewFileIgnores(rules []string) *FileIgnores { return &FileIgnores{patterns: rules} }
 
+// WithBase returns a copy of f for matching paths relative to base, which is
+// a subdirectory of the directory the rules are written for.
+func (f *FileIgnores) WithBase(base string) *FileIgnores {
+	if f == nil {
+		return nil
+	}
+	return &FileIgnores{patterns: f.patterns, base: base}
+}
+
 // ignored checks if the path (OS-dependent file separator) matches one of the exclusion rules (that are in dockerignore format).
 func ignored(path string, exclusions []string) bool {
 	unixPath := filepath.ToSlash(path)
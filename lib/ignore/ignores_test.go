@@ -107,3 +107,21 @@ func TestIgnored(t *testing.T) {
 		})
 	}
 }
+
+func TestFileIgnores_WithBase(t *testing.T) {
+	f := NewFileIgnores([]string{"src/*.pyc", "**/node_modules"}).WithBase("src")
+	if !f.Ignored("foo.pyc") {
+		t.Error("foo.pyc should be ignored relative to src/")
+	}
+	if !f.Ignored("web/node_modules") {
+		t.Error("web/node_modules should be ignored")
+	}
+	if f.Ignored("foo.py") {
+		t.Error("foo.py should not be ignored")
+	}
+
+	var nilIgnores *FileIgnores
+	if nilIgnores.WithBase("src").Ignored("foo") {
+		t.Error("nil ignores should not ignore files")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package procfile parses Procfiles, which declare the processes of an app as
+// "NAME: COMMAND" lines.
+package procfile
+
+import (
+	"bufio"
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/pkg/errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	linePattern       = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*:\s*(.+)$`)
+	annotationPattern = regexp.MustCompile(`\s+#\s?rundev(\[(.*)\])?((?:\s+[a-z]+=\S*)*)\s*$`)
+)
+
+// Parse reads the processes in a Procfile. Commands are run with /bin/sh and
+// can end with a #rundev[PATTERN,..] annotation listing the file changes that
+// restart the process, followed by restart=POLICY and port=PORT options.
+func Parse(r io.Reader) (types.Processes, error) {
+	var out types.Processes
+	seen := make(map[string]bool)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := linePattern.FindStringSubmatch(line)
+		if m == nil {
+			return nil, errors.Errorf("line %d: not in NAME: COMMAND format", n)
+		}
+		p := types.Process{Name: m[1]}
+		if seen[p.Name] {
+			return nil, errors.Errorf("line %d: duplicate process %q", n, p.Name)
+		}
+		seen[p.Name] = true
+
+		cmd := m[2]
+		if a := annotationPattern.FindStringSubmatchIndex(cmd); a != nil {
+			if a[4] >= 0 && cmd[a[4]:a[5]] != "" {
+				p.On = strings.Split(cmd[a[4]:a[5]], ",")
+			}
+			if err := parseOpts(&p, cmd[a[6]:a[7]]); err != nil {
+				return nil, errors.Wrapf(err, "line %d: failed to parse #rundev annotation", n)
+			}
+			cmd = strings.TrimSpace(cmd[:a[0]])
+		}
+		p.C = types.Cmd{"/bin/sh", "-c", cmd}
+		out = append(out, p)
+	}
+	return out, errors.Wrap(s.Err(), "failed to read Procfile")
+}
+
+// parseOpts parses the whitespace-separated KEY=VALUE options of a process
+// annotation into p.
+func parseOpts(p *types.Process, opts string) error {
+	for _, f := range strings.Fields(opts) {
+		kv := strings.SplitN(f, "=", 2)
+		k, v := kv[0], kv[1]
+		switch k {
+		case "restart":
+			p.Restart = v
+		case "port":
+			port, err := strconv.Atoi(v)
+			if err != nil || port <= 0 || port > 65535 {
+				return errors.Errorf("invalid port %q", v)
+			}
+			p.Port = port
+		default:
+			return errors.Errorf("unknown option %q", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package procfile
+
+import (
+	"github.com/ahmetb/rundev/lib/types"
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    types.Processes
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			in:   "\n# comment\n",
+		},
+		{
+			name: "plain",
+			in: `web: python app.py
+css: npx tailwindcss -i in.css -o out.css --watch`,
+			want: types.Processes{
+				{Name: "web", C: types.Cmd{"/bin/sh", "-c", "python app.py"}},
+				{Name: "css", C: types.Cmd{"/bin/sh", "-c", "npx tailwindcss -i in.css -o out.css --watch"}},
+			},
+		},
+		{
+			name: "annotated",
+			in:   `worker: celery -A app worker  # rundev[**/*.py,!tests/**] restart=on-failure port=9000`,
+			want: types.Processes{{
+				Name:    "worker",
+				C:       types.Cmd{"/bin/sh", "-c", "celery -A app worker"},
+				On:      []string{"**/*.py", "!tests/**"},
+				Restart: "on-failure",
+				Port:    9000,
+			}},
+		},
+		{
+			name: "options only",
+			in:   `worker: ./worker #rundev restart=always`,
+			want: types.Processes{{Name: "worker", C: types.Cmd{"/bin/sh", "-c", "./worker"}, Restart: "always"}},
+		},
+		{
+			name:    "no name",
+			in:      `python app.py`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate",
+			in:      "a: x\na: y",
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			in:      `a: x # rundev foo=bar`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			in:      `a: x # rundev port=http`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Parse() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
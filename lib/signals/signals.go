@@ -0,0 +1,131 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signals parses signal names and numbers, like the STOPSIGNAL
+// instruction in Dockerfile. Signal numbers are those of Linux (where the
+// user app runs), so parsing gives the same result on any client platform.
+package signals
+
+import (
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	rtMin = 34 // SIGRTMIN as seen by programs using glibc or musl
+	rtMax = 64
+)
+
+var names = []string{
+	1:  "HUP",
+	2:  "INT",
+	3:  "QUIT",
+	4:  "ILL",
+	5:  "TRAP",
+	6:  "ABRT",
+	7:  "BUS",
+	8:  "FPE",
+	9:  "KILL",
+	10: "USR1",
+	11: "SEGV",
+	12: "USR2",
+	13: "PIPE",
+	14: "ALRM",
+	15: "TERM",
+	16: "STKFLT",
+	17: "CHLD",
+	18: "CONT",
+	19: "STOP",
+	20: "TSTP",
+	21: "TTIN",
+	22: "TTOU",
+	23: "URG",
+	24: "XCPU",
+	25: "XFSZ",
+	26: "VTALRM",
+	27: "PROF",
+	28: "WINCH",
+	29: "IO",
+	30: "PWR",
+	31: "SYS",
+}
+
+var aliases = map[string]int{
+	"IOT":  6,
+	"CLD":  17,
+	"POLL": 29,
+}
+
+// Parse parses a signal name (SIGTERM, TERM, SIGRTMIN+3) or number.
+func Parse(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 || n > rtMax {
+			return 0, errors.Errorf("invalid signal number %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	for n, v := range names {
+		if v != "" && v == name {
+			return syscall.Signal(n), nil
+		}
+	}
+	if n, ok := aliases[name]; ok {
+		return syscall.Signal(n), nil
+	}
+	if n, ok := parseRealtime(name); ok {
+		return syscall.Signal(n), nil
+	}
+	return 0, errors.Errorf("unknown signal %q", s)
+}
+
+// parseRealtime parses RTMIN, RTMAX, RTMIN+n and RTMAX-n.
+func parseRealtime(name string) (int, bool) {
+	var base, sign int
+	switch {
+	case strings.HasPrefix(name, "RTMIN"):
+		base, sign, name = rtMin, 1, strings.TrimPrefix(name, "RTMIN")
+	case strings.HasPrefix(name, "RTMAX"):
+		base, sign, name = rtMax, -1, strings.TrimPrefix(name, "RTMAX")
+	default:
+		return 0, false
+	}
+	if name == "" {
+		return base, true
+	}
+	if (sign > 0 && name[0] != '+') || (sign < 0 && name[0] != '-') {
+		return 0, false
+	}
+	off, err := strconv.Atoi(name[1:])
+	if err != nil || off < 0 || off > rtMax-rtMin {
+		return 0, false
+	}
+	return base + sign*off, true
+}
+
+// Name returns the name of sig that is accepted by Parse, e.g. SIGTERM or
+// SIGRTMIN+3, or its number if it has no name.
+func Name(sig syscall.Signal) string {
+	n := int(sig)
+	switch {
+	case n > 0 && n < len(names):
+		return "SIG" + names[n]
+	case n >= rtMin && n <= rtMax:
+		return "SIGRTMIN+" + strconv.Itoa(n-rtMin)
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signals
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{in: "SIGTERM", want: 15},
+		{in: "quit", want: 3},
+		{in: "INT", want: 2},
+		{in: "9", want: 9},
+		{in: "SIGWINCH", want: 28},
+		{in: "SIGPOLL", want: 29},
+		{in: "SIGRTMIN", want: 34},
+		{in: "SIGRTMIN+3", want: 37},
+		{in: "RTMAX-1", want: 63},
+		{in: "64", want: 64},
+		{in: "SIGFOO", wantErr: true},
+		{in: "SIGRTMIN-1", wantErr: true},
+		{in: "SIGRTMIN+31", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "65", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error=%v, wantErr=%v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Parse(%q)=%d, want=%d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestName(t *testing.T) {
+	for _, in := range []string{"SIGHUP", "SIGTERM", "SIGWINCH", "SIGSYS", "SIGRTMIN+0", "SIGRTMIN+3", "SIGRTMIN+30"} {
+		sig, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Name(sig); got != in {
+			t.Errorf("Name(%d)=%s, want=%s", sig, got, in)
+		}
+	}
+	if got := Name(33); got != "33" {
+		t.Errorf("Name(33)=%s, want=33", got)
+	}
+}
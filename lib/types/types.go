@@ -14,9 +14,78 @@
 
 package types
 
+import "time"
+
 type ProcError struct {
-	Message string `json:"message"`
-	Output  string `json:"output"`
+	Message string     `json:"message"`
+	Output  string     `json:"output"`
+	Exits   []ProcExit `json:"exits,omitempty"` // recent exits of the user process, oldest first
+}
+
+// ProcExit describes how an incarnation of the user process has exited.
+type ProcExit struct {
+	Incarnation int           `json:"incarnation"`
+	Status      string        `json:"status"`           // e.g. "exit status 1", "signal: killed"
+	ExitCode    int           `json:"exitCode"`         // -1 if terminated by a signal
+	Signal      string        `json:"signal,omitempty"` // signal that terminated the process
+	Uptime      time.Duration `json:"uptime"`
+	Logs        string        `json:"logs,omitempty"` // last lines of output
+
+	Stopped      bool          `json:"stopped,omitempty"`      // by rundevd, rather than exiting on its own
+	StopDuration time.Duration `json:"stopDuration,omitempty"` // from the stop signal to exit
+	Killed       bool          `json:"killed,omitempty"`       // grace period ran out and the process group was killed
+}
+
+// LogLine is a line of output (or a lifecycle event) of a process, streamed
+// from rundevd.
+type LogLine struct {
+	Source      string    `json:"source"`                // "build", or the name of the process ("web" for the user app)
+	Stream      string    `json:"stream"`                // stdout, stderr or lifecycle
+	Incarnation int       `json:"incarnation,omitempty"` // of the process
+	Time        time.Time `json:"time"`
+	Text        string    `json:"text"`
+}
+
+// EventType is the kind of an Event.
+type EventType string
+
+const (
+	EventPatchApplied   EventType = "patchApplied"
+	EventBuildStarted   EventType = "buildStarted"
+	EventBuildFinished  EventType = "buildFinished"
+	EventProcessStarted EventType = "processStarted"
+	EventProcessExited  EventType = "processExited"
+	EventRequestProxied EventType = "requestProxied"
+)
+
+// Event is something rundevd did, streamed as JSON lines from
+// /rundevd/events. Fields not relevant to the Type are empty.
+type Event struct {
+	Seq  int       `json:"seq"` // increases with every event of a rundevd instance
+	Time time.Time `json:"time"`
+	Type EventType `json:"type"`
+
+	Checksum uint64        `json:"checksum,omitempty"` // of the mount (patch), or all sync dirs (build)
+	Duration time.Duration `json:"duration,omitempty"` // of the build or the request, uptime of an exited process
+	ExitCode int           `json:"exitCode,omitempty"` // of a failed build cmd or an exited process, -1 if terminated by a signal
+	Error    string        `json:"error,omitempty"`    // of a failed build
+	Canceled bool          `json:"canceled,omitempty"` // build canceled by a newer patch
+
+	Mount   *int   `json:"mount,omitempty"`   // index of the patched sync dir, set for patches
+	Changes int    `json:"changes,omitempty"` // number of files changed by the patch
+	Action  string `json:"action,omitempty"`  // how the patch is picked up: none, signal, restart or rebuild
+
+	Process     string `json:"process,omitempty"` // "web" for the user app, or the name of the process
+	Incarnation int    `json:"incarnation,omitempty"`
+	Pid         int    `json:"pid,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Status      string `json:"status,omitempty"`  // e.g. "exit status 1", "signal: killed"
+	Signal      string `json:"signal,omitempty"`  // that terminated the process
+	Stopped     bool   `json:"stopped,omitempty"` // by rundevd, rather than exiting on its own
+
+	Method     string `json:"method,omitempty"` // of the proxied request
+	Path       string `json:"path,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"`
 }
 
 type Cmd []string
@@ -36,8 +105,134 @@ func (c Cmd) Args() []string {
 }
 
 type BuildCmd struct {
-	C  Cmd      `json:"c"`
-	On []string `json:"on,omitempty"` // file patterns
+	C       Cmd           `json:"c"`
+	On      []string      `json:"on,omitempty"`      // file patterns, in [!][TYPE|..:]GLOB form
+	Dir     string        `json:"dir,omitempty"`     // working directory, relative to the app's working directory if not absolute
+	Env     []string      `json:"env,omitempty"`     // KEY=VALUE pairs added to the environment
+	Timeout time.Duration `json:"timeout,omitempty"` // zero means no timeout
 }
 
 type BuildCmds []BuildCmd
+
+// Process is a named process supervised along with the user app, such as a
+// queue worker or an asset watcher.
+type Process struct {
+	Name    string   `json:"name"`
+	C       Cmd      `json:"c"`
+	On      []string `json:"on,omitempty"`      // file patterns restarting the process, in [!][TYPE|..:]GLOB form
+	Restart string   `json:"restart,omitempty"` // restart policy, daemon's default if empty
+	Port    int      `json:"port,omitempty"`    // passed as PORT if not zero
+}
+
+type Processes []Process
+
+// ReloadRule decides how the app is reloaded when the files matching On
+// change.
+type ReloadRule struct {
+	On     []string `json:"on"`               // file patterns, in [!][TYPE|..:]GLOB form
+	Action string   `json:"action"`           // none, signal, restart or rebuild
+	Signal string   `json:"signal,omitempty"` // sent with the signal action, SIGHUP if empty
+}
+
+type ReloadRules []ReloadRule
+
+// Mount is a local directory synced to a directory in the container.
+type Mount struct {
+	Local      string   `json:"local"`                // relative to the build context (slash-separated)
+	Remote     string   `json:"remote"`               // directory in the container
+	Ignores    []string `json:"ignores,omitempty"`    // exclusion rules in .dockerignore format
+	IgnoreBase string   `json:"ignoreBase,omitempty"` // path of the mount relative to the directory Ignores are written for
+}
+
+type Mounts []Mount
+
+// DaemonStatus is the status of rundevd, served by /rundevd/debugz as JSON.
+type DaemonStatus struct {
+	Version        string          `json:"version"` // of rundevd
+	GoVersion      string          `json:"goVersion"`
+	Pid            int             `json:"pid"`
+	Incarnation    string          `json:"incarnation"` // of the rundevd instance
+	Cwd            string          `json:"cwd"`
+	Checksum       uint64          `json:"checksum"` // of all sync dirs
+	Mounts         []MountStatus   `json:"mounts"`
+	Process        ProcessStatus   `json:"process"`             // the user app
+	Processes      []ProcessStatus `json:"processes,omitempty"` // named processes
+	PendingChanges []string        `json:"pendingChanges"`      // files changed since the last successful build
+	LastBuild      *BuildStatus    `json:"lastBuild,omitempty"`
+	SwapFailure    *SwapFailure    `json:"swapFailure,omitempty"` // new version failed to start with -blue-green
+	Options        DaemonOptions   `json:"options"`
+}
+
+// ClientStatus is the status of the rundev client, served by /rundev/debugz
+// as JSON.
+type ClientStatus struct {
+	Version   string        `json:"version"` // of rundev
+	GoVersion string        `json:"goVersion"`
+	Pid       int           `json:"pid"`
+	Cwd       string        `json:"cwd"`
+	Checksum  uint64        `json:"checksum"` // of all sync dirs
+	Target    string        `json:"target"`   // rundevd address
+	Mounts    []MountStatus `json:"mounts"`
+}
+
+// MountStatus is a synced directory.
+type MountStatus struct {
+	Local    string   `json:"local"`
+	Remote   string   `json:"remote"`
+	Checksum uint64   `json:"checksum"`
+	Ignores  []string `json:"ignores,omitempty"`
+}
+
+// ProcessStatus is the state of a supervised process.
+type ProcessStatus struct {
+	Name         string     `json:"name"`
+	Running      bool       `json:"running"`
+	Pid          int        `json:"pid,omitempty"`
+	Port         int        `json:"port,omitempty"`
+	CrashLooping bool       `json:"crashLooping"`
+	Exits        []ProcExit `json:"exits,omitempty"`    // recent exits, oldest first
+	LastExit     *ProcExit  `json:"lastExit,omitempty"` // including processes stopped by rundevd
+}
+
+// BuildStatus is the result of a build.
+type BuildStatus struct {
+	Checksum uint64        `json:"checksum"` // of the tree built
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Steps    []BuildStep   `json:"steps"` // build cmds executed
+}
+
+// BuildStep is a build cmd executed in a build.
+type BuildStep struct {
+	Cmd      int           `json:"cmd"` // index in the build cmds
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// SwapFailure is a new version of the user app that failed to start.
+type SwapFailure struct {
+	Checksum uint64    `json:"checksum"`
+	Time     time.Time `json:"time"`
+	Error    string    `json:"error"`
+}
+
+// DaemonOptions are the options rundevd runs with.
+type DaemonOptions struct {
+	WorkDir         string        `json:"workDir"`
+	RunCmd          Cmd           `json:"runCmd"`
+	BuildCmds       BuildCmds     `json:"buildCmds"`
+	Processes       Processes     `json:"processes,omitempty"`
+	ReloadRules     ReloadRules   `json:"reloadRules,omitempty"`
+	Port            int           `json:"port"`
+	AltPort         int           `json:"altPort,omitempty"` // with BlueGreen
+	PortWaitTimeout time.Duration `json:"portWaitTimeout"`
+	ReadyPath       string        `json:"readyPath,omitempty"`
+	ReadyInterval   time.Duration `json:"readyInterval,omitempty"`
+	EagerBuild      bool          `json:"eagerBuild"`
+	Worker          bool          `json:"worker"`
+	BlueGreen       bool          `json:"blueGreen"`
+	StopSignal      string        `json:"stopSignal"`
+	StopGracePeriod time.Duration `json:"stopGracePeriod"`
+	RestartPolicy   string        `json:"restartPolicy"`
+	RestartBackoff  time.Duration `json:"restartBackoff"`
+}